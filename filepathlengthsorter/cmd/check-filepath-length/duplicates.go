@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"filepathlengthsorter"
+)
+
+// runDuplicates implements the duplicates subcommand and returns the exit
+// code
+func runDuplicates(args []string) int {
+	fs := flag.NewFlagSet("duplicates", flag.ExitOnError)
+	action := fs.String("action", "", "What to do with every copy but the first: hardlink or quarantine (default only report)")
+	quarantineDir := fs.String("quarantine", "", "Where -action quarantine moves copies to")
+	dryRun := fs.Bool("dry-run", false, "Only report what -action would do")
+	manifestPath := fs.String("manifest", "", "Where to journal what -action did (default duplicates-<timestamp>.jsonl)")
+	buildFilter := filepathlengthsorter.FilterFlags(fs)
+	fs.Parse(args)
+
+	roots := fs.Args()
+	if len(roots) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: check-filepath-length duplicates [-action hardlink|quarantine] [-quarantine dir] [-dry-run] <dir>...")
+		return exitError
+	}
+	switch *action {
+	case "", filepathlengthsorter.DuplicatesHardlink:
+	case filepathlengthsorter.DuplicatesQuarantine:
+		if *quarantineDir == "" {
+			fmt.Fprintln(os.Stderr, "-action quarantine needs -quarantine")
+			return exitError
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown action %q\n", *action)
+		return exitError
+	}
+	filter, err := buildFilter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	groups, errorFiles := filepathlengthsorter.FindDuplicates(ctx, roots, filter)
+
+	var files int
+	var wasted int64
+	for _, group := range groups {
+		files += len(group.Paths)
+		wasted += group.Wasted()
+		fmt.Printf("\n%d copies of %s, %s wasted:\n", len(group.Paths), filepathlengthsorter.FormatFileSize(group.Size), filepathlengthsorter.FormatFileSize(group.Wasted()))
+		for i, path := range group.Paths {
+			marker := " "
+			if i == 0 {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, path)
+		}
+	}
+	fmt.Printf("\nFound %d groups of duplicates, %d files, wasting %s\n", len(groups), files, filepathlengthsorter.FormatFileSize(wasted))
+
+	if *action != "" && len(groups) > 0 {
+		opts := filepathlengthsorter.DuplicateOptions{
+			Action:        *action,
+			QuarantineDir: *quarantineDir,
+			Roots:         roots,
+			ManifestPath:  *manifestPath,
+		}
+		if !*dryRun && opts.ManifestPath == "" {
+			opts.ManifestPath = fmt.Sprintf("duplicates-%s.jsonl", time.Now().Format("20060102-150405"))
+		}
+		fmt.Println()
+		done, actionErrors := filepathlengthsorter.ResolveDuplicates(ctx, groups, *dryRun, opts)
+		errorFiles = append(errorFiles, actionErrors...)
+		if !*dryRun {
+			fmt.Printf("Handled %d copies, recorded in %s\n", len(done), opts.ManifestPath)
+		}
+	}
+
+	if len(errorFiles) > 0 {
+		fmt.Printf("\nEncountered %d errors:\n", len(errorFiles))
+		for _, err := range errorFiles {
+			fmt.Printf("File: %s\nError: %v\n\n", err.Path, err.Error)
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("\nThis was a dry run - nothing was changed.")
+	}
+
+	switch {
+	case len(errorFiles) > 0:
+		return exitError
+	case (*action == "" || *dryRun) && len(groups) > 0:
+		return exitDryRunHits
+	default:
+		return exitClean
+	}
+}
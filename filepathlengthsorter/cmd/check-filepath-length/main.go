@@ -0,0 +1,278 @@
+// Command check-filepath-length moves files whose paths are too long out
+// of a directory tree, or shortens their names in place. It can also find
+// duplicate files.
+//
+// Usage:
+//
+//	check-filepath-length [-dry-run] -src <dir> -dst <dir> [-max n] [flags]
+//	check-filepath-length shorten -src <dir> [-max n] [-apply] [flags]
+//	check-filepath-length undo [-dry-run] -manifest <file>
+//	check-filepath-length duplicates [-action hardlink|quarantine] [-dry-run] <dir>...
+//
+// Without -src and -dst it asks for them when run on a terminal.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"filepathlengthsorter"
+)
+
+// Exit codes returned by main
+const (
+	exitClean      = 0 // nothing to move, or everything moved without errors
+	exitError      = 1 // at least one error occurred
+	exitDryRunHits = 2 // dry run found files that would be moved
+)
+
+// isInteractive reports whether stdin is a terminal we can prompt on
+func isInteractive() bool {
+	return isTerminal(os.Stdin)
+}
+
+// isTerminal reports whether file is a terminal. The null device is a
+// character device too, so it is ruled out explicitly.
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	if null, err := os.Stat(os.DevNull); err == nil && os.SameFile(info, null) {
+		return false
+	}
+	return true
+}
+
+// prompt asks the user for a value on stdin
+func prompt(reader *bufio.Reader, question string) string {
+	fmt.Print(question)
+	answer, _ := reader.ReadString('\n')
+	return strings.TrimSpace(answer)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "undo":
+			os.Exit(runUndo(os.Args[2:]))
+		case "shorten":
+			os.Exit(runShorten(os.Args[2:]))
+		case "duplicates":
+			os.Exit(runDuplicates(os.Args[2:]))
+		}
+	}
+	os.Exit(run())
+}
+
+// run parses the command line, performs the move and returns the exit code
+func run() int {
+	// Define command line flags
+	dryRun := flag.Bool("dry-run", false, "Perform a dry run (no files will be moved)")
+	sourceFlag := flag.String("src", "", "Source directory to scan")
+	targetFlag := flag.String("dst", "", "Target directory for files with long paths")
+	maxFlag := flag.Int("max", filepathlengthsorter.DefaultMaxLength, "Maximum path length")
+	preserveOwner := flag.Bool("preserve-owner", false, "Keep file ownership when copying across filesystems (usually needs root)")
+	preserveXattrs := flag.Bool("preserve-xattrs", false, "Keep extended attributes when copying across filesystems")
+	verify := flag.Bool("verify", false, "Compare SHA-256 checksums of each copy before deleting the original")
+	strategy := flag.String("strategy", filepathlengthsorter.StrategyFlatten, "Where moved files go: flatten, mirror, bucket or fit")
+	segmentLength := flag.Int("segment-length", 32, "Longest directory name kept by the mirror strategy")
+	buildMeasure := filepathlengthsorter.MeasureFlags(flag.CommandLine)
+	buildFilter := filepathlengthsorter.FilterFlags(flag.CommandLine)
+	manifestPath := flag.String("manifest", "", "Where to journal moves for undo (default moves-<timestamp>.jsonl)")
+	workers := flag.Int("workers", runtime.NumCPU(), "How many files to move at the same time")
+	showProgress := flag.Bool("progress", isTerminal(os.Stderr), "Show progress and an ETA on stderr")
+	caseInsensitive := flag.Bool("case-insensitive", false, "Treat target names that differ only in case as the same file")
+	format := flag.String("format", FormatText, "Output format: text, json, csv or ndjson. Anything but text sends the run log to stderr")
+	statePath := flag.String("state", "", "Where to save the plan of a real run for -resume (default named after the manifest)")
+	resumePath := flag.String("resume", "", "Carry on with the run whose plan was saved in this state file")
+	flag.Parse()
+
+	sourceDir, targetDir, maxLength := *sourceFlag, *targetFlag, *maxFlag
+
+	// A resumed run takes everything that shaped the plan from the state
+	var state filepathlengthsorter.RunState
+	if *resumePath != "" {
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "-resume cannot be combined with -dry-run")
+			return exitError
+		}
+		var err error
+		if state, err = filepathlengthsorter.ReadState(*resumePath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading state: %v\n", err)
+			return exitError
+		}
+		sourceDir, targetDir, maxLength = state.Source, state.Target, state.MaxLength
+		*strategy, *manifestPath, *statePath = cmp.Or(state.Strategy, filepathlengthsorter.StrategyFlatten), state.Manifest, *resumePath
+	}
+
+	// Fall back to prompting for the directories if they are missing, but
+	// only on a terminal. -max has a default and is never asked for.
+	if *resumePath == "" && isInteractive() {
+		reader := bufio.NewReader(os.Stdin)
+
+		if sourceDir == "" {
+			sourceDir = prompt(reader, "Enter source directory path: ")
+		}
+		if targetDir == "" {
+			targetDir = prompt(reader, "Enter target directory path: ")
+		}
+	}
+
+	if sourceDir == "" || targetDir == "" {
+		fmt.Fprintln(os.Stderr, "Both -src and -dst are required when not running interactively")
+		flag.Usage()
+		return exitError
+	}
+	if !validFormat(*format) {
+		fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+		return exitError
+	}
+	if !filepathlengthsorter.ValidStrategy(*strategy) {
+		fmt.Fprintf(os.Stderr, "Unknown strategy %q\n", *strategy)
+		return exitError
+	}
+	if maxLength <= 0 {
+		fmt.Fprintf(os.Stderr, "Maximum path length must be positive, got %d\n", maxLength)
+		return exitError
+	}
+	measure, err := buildMeasure()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	filter, err := buildFilter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	opts := filepathlengthsorter.MoveOptions{
+		PreserveOwner:   *preserveOwner,
+		PreserveXattrs:  *preserveXattrs,
+		Verify:          *verify,
+		ManifestPath:    *manifestPath,
+		Strategy:        *strategy,
+		SegmentLength:   *segmentLength,
+		Measure:         measure,
+		Workers:         *workers,
+		Filter:          filter,
+		CaseInsensitive: *caseInsensitive,
+	}
+	if *showProgress {
+		opts.Progress = os.Stderr
+	}
+	if *format != FormatText {
+		// Keep stdout for the report alone
+		opts.Log = os.Stderr
+	}
+	if !*dryRun {
+		opts.ManifestPath = cmp.Or(opts.ManifestPath, filepathlengthsorter.DefaultManifestPath())
+		opts.StatePath = cmp.Or(*statePath, filepathlengthsorter.DefaultStatePath(opts.ManifestPath))
+	}
+	// The first Ctrl-C lets the moves in flight finish, a second one kills
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	var movedFiles []filepathlengthsorter.FileMove
+	var errorFiles []filepathlengthsorter.FileMoveError
+	if *resumePath != "" {
+		movedFiles, errorFiles = filepathlengthsorter.ResumeMoves(ctx, state, opts)
+	} else {
+		movedFiles, errorFiles = filepathlengthsorter.MoveLongPaths(ctx, sourceDir, targetDir, maxLength, *dryRun, opts)
+	}
+
+	var issues []filepathlengthsorter.PathIssue
+	if measure.HasComponentRules() {
+		var issueErrors []filepathlengthsorter.FileMoveError
+		issues, issueErrors = filepathlengthsorter.FindPathIssues(sourceDir, measure, filter)
+		errorFiles = append(errorFiles, issueErrors...)
+	}
+
+	if *format != FormatText {
+		r := newReport(sourceDir, targetDir, maxLength, *dryRun, opts, movedFiles, errorFiles, issues)
+		if err := writeReport(os.Stdout, *format, r); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing report: %v\n", err)
+			return exitError
+		}
+		if !*dryRun && len(movedFiles) > 0 {
+			fmt.Fprintf(os.Stderr, "Moves were recorded in %s\n", opts.ManifestPath)
+		}
+		return exitCode(*dryRun, movedFiles, errorFiles)
+	}
+
+	// Print summary
+	if measure.HasComponentRules() {
+		fmt.Printf("\nFound %d files with paths longer than %d characters or names the destination would reject\n", len(movedFiles), maxLength)
+	} else {
+		fmt.Printf("\nFound %d files with paths longer than %d characters\n", len(movedFiles), maxLength)
+	}
+
+	if len(movedFiles) > 0 {
+		fmt.Println("\nFiles to be moved:")
+		var totalSize int64
+		for _, move := range movedFiles {
+			totalSize += move.FileSize
+			fmt.Printf("\nFrom: %s\nTo: %s\nSize: %s\n",
+				move.OriginalPath,
+				move.NewPath,
+				filepathlengthsorter.FormatFileSize(move.FileSize))
+			if move.Checksum != "" {
+				fmt.Printf("SHA-256: %s\n", move.Checksum)
+			}
+		}
+		fmt.Printf("\nTotal size: %s\n", filepathlengthsorter.FormatFileSize(totalSize))
+	}
+
+	if len(issues) > 0 {
+		printPathIssues(issues)
+	}
+
+	if len(errorFiles) > 0 {
+		fmt.Printf("\nEncountered %d errors:\n", len(errorFiles))
+		for _, err := range errorFiles {
+			fmt.Printf("File: %s\nError: %v\n\n", err.Path, err.Error)
+		}
+	}
+
+	if *dryRun {
+		fmt.Println("\nThis was a dry run - no files were actually moved.")
+		fmt.Println("Run without --dry-run flag to perform the actual move operation.")
+	} else if len(movedFiles) > 0 {
+		fmt.Printf("\nMoves were recorded in %s\n", opts.ManifestPath)
+		fmt.Printf("Run \"check-filepath-length undo -manifest %s\" to move them back.\n", opts.ManifestPath)
+	}
+
+	return exitCode(*dryRun, movedFiles, errorFiles)
+}
+
+// exitCode picks the exit code for the outcome of a run
+func exitCode(dryRun bool, movedFiles []filepathlengthsorter.FileMove, errorFiles []filepathlengthsorter.FileMoveError) int {
+	switch {
+	case len(errorFiles) > 0:
+		return exitError
+	case dryRun && len(movedFiles) > 0:
+		return exitDryRunHits
+	default:
+		return exitClean
+	}
+}
+
+// printPathIssues prints the component report
+func printPathIssues(issues []filepathlengthsorter.PathIssue) {
+	fmt.Printf("\nFound %d names the destination would reject:\n", len(issues))
+	for _, issue := range issues {
+		fmt.Printf("%s\n  %s\n", issue.Path, issue.Problem)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"filepathlengthsorter"
+)
+
+// Report formats selectable with -format
+const (
+	FormatText   = "text"   // the human-readable summary
+	FormatJSON   = "json"   // a single JSON document
+	FormatCSV    = "csv"    // one row per move, error or path issue
+	FormatNDJSON = "ndjson" // one JSON object per move, error or path issue
+)
+
+// validFormat reports whether name is one of the Format constants
+func validFormat(name string) bool {
+	switch name {
+	case FormatText, FormatJSON, FormatCSV, FormatNDJSON:
+		return true
+	}
+	return false
+}
+
+// reportMove is a planned or completed move as it appears in reports
+type reportMove struct {
+	OriginalPath string     `json:"original_path"`
+	NewPath      string     `json:"new_path"`
+	Length       int        `json:"length"`     // measured length of the original path
+	Excess       int        `json:"excess"`     // how far Length is over the limit
+	NewLength    int        `json:"new_length"` // measured length of the new path
+	Size         int64      `json:"size"`
+	Checksum     string     `json:"sha256,omitempty"`
+	MovedAt      *time.Time `json:"moved_at,omitempty"` // nil in dry runs
+}
+
+// reportError is a FileMoveError as it appears in reports
+type reportError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// report is everything a run found or did, in the shape of the JSON format
+type report struct {
+	DryRun     bool                             `json:"dry_run"`
+	Source     string                           `json:"source"`
+	Target     string                           `json:"target"`
+	MaxLength  int                              `json:"max_length"`
+	Unit       string                           `json:"unit"`
+	Manifest   string                           `json:"manifest,omitempty"`
+	TotalSize  int64                            `json:"total_size"`
+	Moves      []reportMove                     `json:"moves"`
+	Errors     []reportError                    `json:"errors"`
+	PathIssues []filepathlengthsorter.PathIssue `json:"path_issues,omitempty"`
+}
+
+// newReport gathers the results of a run. Lengths are measured the same
+// way MoveLongPaths measured them.
+func newReport(sourceDir, targetDir string, maxLength int, dryRun bool, opts filepathlengthsorter.MoveOptions, moves []filepathlengthsorter.FileMove, errorFiles []filepathlengthsorter.FileMoveError, issues []filepathlengthsorter.PathIssue) report {
+	absSourceDir, _ := filepath.Abs(sourceDir)
+	absTargetDir, _ := filepath.Abs(targetDir)
+	r := report{
+		DryRun:     dryRun,
+		Source:     absSourceDir,
+		Target:     absTargetDir,
+		MaxLength:  maxLength,
+		Unit:       opts.Measure.Unit,
+		Moves:      []reportMove{},
+		Errors:     []reportError{},
+		PathIssues: issues,
+	}
+	if r.Unit == "" {
+		r.Unit = filepathlengthsorter.UnitBytes
+	}
+	if !dryRun {
+		r.Manifest = opts.ManifestPath
+	}
+
+	for _, move := range moves {
+		length := opts.Measure.Length(move.OriginalPath, absSourceDir)
+		entry := reportMove{
+			OriginalPath: move.OriginalPath,
+			NewPath:      move.NewPath,
+			Length:       length,
+			Excess:       length - maxLength,
+			NewLength:    opts.Measure.Count(move.NewPath),
+			Size:         move.FileSize,
+			Checksum:     move.Checksum,
+		}
+		if !move.MovedAt.IsZero() {
+			entry.MovedAt = &move.MovedAt
+		}
+		r.Moves = append(r.Moves, entry)
+		r.TotalSize += move.FileSize
+	}
+	for _, err := range errorFiles {
+		r.Errors = append(r.Errors, reportError{Path: err.Path, Error: err.Error.Error()})
+	}
+	return r
+}
+
+// writeReport writes r to w in format, which must not be FormatText
+func writeReport(w io.Writer, format string, r report) error {
+	switch format {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case FormatNDJSON:
+		return writeNDJSON(w, r)
+	case FormatCSV:
+		return writeCSV(w, r)
+	}
+	return fmt.Errorf("unknown format %q", format)
+}
+
+// writeNDJSON writes one object per line, each tagged with its type
+func writeNDJSON(w io.Writer, r report) error {
+	enc := json.NewEncoder(w)
+	for _, move := range r.Moves {
+		if err := enc.Encode(struct {
+			Type string `json:"type"`
+			reportMove
+		}{"move", move}); err != nil {
+			return err
+		}
+	}
+	for _, err := range r.Errors {
+		if err := enc.Encode(struct {
+			Type string `json:"type"`
+			reportError
+		}{"error", err}); err != nil {
+			return err
+		}
+	}
+	for _, issue := range r.PathIssues {
+		if err := enc.Encode(struct {
+			Type    string `json:"type"`
+			Path    string `json:"path"`
+			Problem string `json:"problem"`
+		}{"issue", issue.Path, issue.Problem}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// csvHeader lists the columns of the CSV format. Errors and path issues
+// only fill in path and message.
+var csvHeader = []string{"type", "path", "new_path", "length", "excess", "new_length", "size", "sha256", "moved_at", "message"}
+
+func writeCSV(w io.Writer, r report) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, move := range r.Moves {
+		movedAt := ""
+		if move.MovedAt != nil {
+			movedAt = move.MovedAt.Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			"move",
+			move.OriginalPath,
+			move.NewPath,
+			strconv.Itoa(move.Length),
+			strconv.Itoa(move.Excess),
+			strconv.Itoa(move.NewLength),
+			strconv.FormatInt(move.Size, 10),
+			move.Checksum,
+			movedAt,
+			"",
+		})
+	}
+	for _, err := range r.Errors {
+		cw.Write([]string{"error", err.Path, "", "", "", "", "", "", "", err.Error})
+	}
+	for _, issue := range r.PathIssues {
+		cw.Write([]string{"issue", issue.Path, "", "", "", "", "", "", "", issue.Problem})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"filepathlengthsorter"
+)
+
+// writePlan saves plan as indented JSON
+func writePlan(path string, plan []filepathlengthsorter.Rename) error {
+	if plan == nil {
+		plan = []filepathlengthsorter.Rename{}
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// runShorten implements the shorten subcommand and returns the exit code
+func runShorten(args []string) int {
+	fs := flag.NewFlagSet("shorten", flag.ExitOnError)
+	sourceDir := fs.String("src", "", "Directory whose long paths are shortened in place")
+	maxLength := fs.Int("max", filepathlengthsorter.DefaultMaxLength, "Maximum path length")
+	method := fs.String("method", filepathlengthsorter.MethodTruncate, "How names are shortened: truncate, vowels or rules")
+	rulesPath := fs.String("rules", "", "File of long=short abbreviations for the rules method")
+	planPath := fs.String("plan", "rename-plan.json", "Where to write the list of renames")
+	apply := fs.Bool("apply", false, "Perform the renames instead of only planning them")
+	manifestPath := fs.String("manifest", "", "Where to journal renames for undo (default moves-<timestamp>.jsonl)")
+	buildMeasure := filepathlengthsorter.MeasureFlags(fs)
+	fs.Parse(args)
+
+	if *sourceDir == "" {
+		fmt.Fprintln(os.Stderr, "Usage: check-filepath-length shorten -src <dir> [-max n] [-method truncate|vowels|rules] [-rules file] [-apply]")
+		return exitError
+	}
+	if *maxLength <= 0 {
+		fmt.Fprintf(os.Stderr, "Maximum path length must be positive, got %d\n", *maxLength)
+		return exitError
+	}
+
+	measure, err := buildMeasure()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+
+	var abbreviations []filepathlengthsorter.Abbreviation
+	switch *method {
+	case filepathlengthsorter.MethodTruncate, filepathlengthsorter.MethodVowels:
+	case filepathlengthsorter.MethodRules:
+		if *rulesPath == "" {
+			fmt.Fprintln(os.Stderr, "The rules method needs -rules")
+			return exitError
+		}
+		if abbreviations, err = filepathlengthsorter.LoadAbbreviations(*rulesPath); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading rules: %v\n", err)
+			return exitError
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown method %q\n", *method)
+		return exitError
+	}
+
+	fmt.Printf("\nMode: %s\n", map[bool]string{false: "DRY RUN (nothing will be renamed)", true: "ACTUAL RUN"}[*apply])
+	fmt.Printf("Processing source directory: %s\n", *sourceDir)
+	fmt.Printf("Maximum path length: %d %s\n\n", *maxLength, cmp.Or(measure.Unit, filepathlengthsorter.UnitBytes))
+
+	plan, errorFiles := filepathlengthsorter.PlanShortening(*sourceDir, *maxLength, filepathlengthsorter.ShortenOptions{Measure: measure, Method: *method, Abbreviations: abbreviations})
+	if err := writePlan(*planPath, plan); err != nil {
+		errorFiles = append(errorFiles, filepathlengthsorter.FileMoveError{Path: *planPath, Error: err})
+	}
+
+	fmt.Printf("\nPlanned %d renames, written to %s\n", len(plan), *planPath)
+	for _, rename := range plan {
+		fmt.Printf("\nRename: %s\nTo: %s\n", rename.Path, rename.NewName)
+	}
+
+	if *apply && len(plan) > 0 {
+		if *manifestPath == "" {
+			*manifestPath = filepathlengthsorter.DefaultManifestPath()
+		}
+		done, applyErrors := filepathlengthsorter.ApplyShortening(plan, filepathlengthsorter.ShortenOptions{ManifestPath: *manifestPath})
+		errorFiles = append(errorFiles, applyErrors...)
+		fmt.Printf("\nRenamed %d entries, recorded in %s\n", len(done), *manifestPath)
+	}
+
+	if len(errorFiles) > 0 {
+		fmt.Printf("\nEncountered %d errors:\n", len(errorFiles))
+		for _, err := range errorFiles {
+			fmt.Printf("File: %s\nError: %v\n\n", err.Path, err.Error)
+		}
+	}
+
+	if !*apply {
+		fmt.Println("\nThis was a dry run - nothing was renamed.")
+		fmt.Println("Review the plan and run again with -apply to perform the renames.")
+	}
+
+	switch {
+	case len(errorFiles) > 0:
+		return exitError
+	case !*apply && len(plan) > 0:
+		return exitDryRunHits
+	default:
+		return exitClean
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"filepathlengthsorter"
+)
+
+// runUndo implements the undo subcommand and returns the exit code
+func runUndo(args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ExitOnError)
+	manifestPath := fs.String("manifest", "", "Manifest written by the run to undo")
+	dryRun := fs.Bool("dry-run", false, "Only report what would be restored")
+	verify := fs.Bool("verify", false, "Refuse to restore files whose checksum no longer matches the manifest")
+	preserveOwner := fs.Bool("preserve-owner", false, "Keep file ownership when copying across filesystems (usually needs root)")
+	preserveXattrs := fs.Bool("preserve-xattrs", false, "Keep extended attributes when copying across filesystems")
+	fs.Parse(args)
+
+	if *manifestPath == "" && fs.NArg() == 1 {
+		*manifestPath = fs.Arg(0)
+	}
+	if *manifestPath == "" {
+		fmt.Fprintln(os.Stderr, "Usage: check-filepath-length undo [-dry-run] [-verify] -manifest <file>")
+		return exitError
+	}
+
+	opts := filepathlengthsorter.MoveOptions{
+		PreserveOwner:  *preserveOwner,
+		PreserveXattrs: *preserveXattrs,
+		Verify:         *verify,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	restored, errorFiles := filepathlengthsorter.UndoMoves(ctx, *manifestPath, *dryRun, opts)
+
+	fmt.Printf("\n%d files restored, %d conflicts or errors\n", len(restored), len(errorFiles))
+	if len(errorFiles) > 0 {
+		fmt.Println("\nNot restored:")
+		for _, err := range errorFiles {
+			fmt.Printf("File: %s\nError: %v\n\n", err.Path, err.Error)
+		}
+	}
+
+	switch {
+	case len(errorFiles) > 0:
+		return exitError
+	case *dryRun && len(restored) > 0:
+		return exitDryRunHits
+	default:
+		return exitClean
+	}
+}
@@ -0,0 +1,892 @@
+// Command compare-directories-files lists the files that exist in only one
+// of two directory trees and, with -mode, how the files they have in common
+// differ. Given more than two trees it prints which file exists in which
+// instead, and with -base it classifies the changes two copies of the base
+// made as added, removed or conflicting, like a three-way merge. Like diff
+// it exits 0 when the trees are the same, 1 when they differ and 2 when
+// something went wrong. The sync command makes dir2 a copy of dir1 instead.
+//
+// The snapshot command records the files of a tree in a compressed
+// manifest, which can be given in place of any directory to compare with a
+// tree that is not mounted here. Comparing with -mode hash needs a snapshot
+// taken with -hash.
+//
+// Usage:
+//
+//	compare-directories-files [-mode names|size|mtime|hash] [-detect-moves] [-format text|tree|json|csv|patch] <dir1> <dir2>
+//	compare-directories-files [-mode names|size|mtime|hash] [-format text|json|csv] <dir1> <dir2> <dir3>...
+//	compare-directories-files -base <dir> [-mode names|size|mtime|hash] [-format text|json|csv] <left> <right>
+//	compare-directories-files snapshot [-hash] [-o file] <dir>
+//	compare-directories-files sync [-dry-run] [-delete] [-mode size|mtime|hash] [-verify] [-bwlimit rate] <dir1> <dir2>
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"filepathlengthsorter"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Ways of comparing the files present in both trees, each doing more work
+// than the one before
+const (
+	modeNames = "names" // only report which files exist on one side
+	modeSize  = "size"  // also compare sizes
+	modeMtime = "mtime" // also compare modification times
+	modeHash  = "hash"  // also compare the SHA-256 of the content
+)
+
+// How a file present in both trees compares
+const (
+	statusIdentical = "identical"
+	statusSize      = "different size"
+	statusMtime     = "different mtime"
+	statusContent   = "different content"
+	statusOnly1     = "only in dir1"
+	statusOnly2     = "only in dir2"
+	statusMoved     = "moved"
+)
+
+// Output formats
+const (
+	formatText  = "text"  // a section per kind of difference and a summary
+	formatTree  = "tree"  // both trees merged, every file marked
+	formatJSON  = "json"  // the whole comparison as one document
+	formatCSV   = "csv"   // a row per file
+	formatPatch = "patch" // a "+", "-", "M" or "R" line per difference
+)
+
+// Exit codes, the same as diff uses
+const (
+	exitDiffer  = 1 // the trees differ
+	exitTrouble = 2 // something could not be read or compared
+)
+
+// hashCache remembers the SHA-256 of every file hashed so far, and of every
+// file read from a snapshot, by path. Files of snapshots taken without
+// hashes are remembered as "".
+var hashCache = make(map[string]string)
+
+// errNoHash is returned for files of snapshots taken without -hash
+var errNoHash = errors.New("the snapshot has no hash for it, take it with -hash")
+
+// hashFile returns the hex SHA-256 of the file at path
+func hashFile(path string) (string, error) {
+    if sum, ok := hashCache[path]; ok {
+        if sum == "" {
+            return "", errNoHash
+        }
+        return sum, nil
+    }
+
+    sum, err := filepathlengthsorter.HashFile(path)
+    if err != nil {
+        return "", err
+    }
+    hashCache[path] = sum
+    return sum, nil
+}
+
+// compareFiles classifies two files with the same relative path. Sizes are
+// compared first, since that is free, then times or content as mode asks.
+func compareFiles(path1, path2 string, info1, info2 os.FileInfo, mode string, tolerance time.Duration) (string, error) {
+    if info1.Size() != info2.Size() {
+        return statusSize, nil
+    }
+
+    switch mode {
+    case modeMtime:
+        diff := info1.ModTime().Sub(info2.ModTime())
+        if diff < -tolerance || diff > tolerance {
+            return statusMtime, nil
+        }
+    case modeHash:
+        sum1, err := hashFile(path1)
+        if err != nil {
+            return "", err
+        }
+        sum2, err := hashFile(path2)
+        if err != nil {
+            return "", err
+        }
+        if sum1 != sum2 {
+            return statusContent, nil
+        }
+    }
+    return statusIdentical, nil
+}
+
+// move is a file that is only in one tree under one path and only in the
+// other under another, with the same content
+type move struct {
+    From string `json:"from"`
+    To   string `json:"to"`
+}
+
+// detectMoves pairs files only in dir1 with files only in dir2 that have
+// the same content. Only files of equal size are hashed, and each file is
+// paired at most once.
+func detectMoves(dir1, dir2 string, only1, only2 map[string]os.FileInfo) ([]move, error) {
+    bySize := make(map[int64][]string)
+    for rel, info := range only2 {
+        bySize[info.Size()] = append(bySize[info.Size()], rel)
+    }
+
+    candidates := make([]string, 0, len(only1))
+    for rel := range only1 {
+        candidates = append(candidates, rel)
+    }
+    sort.Strings(candidates)
+
+    var moves []move
+    paired := make(map[string]bool)
+    for _, rel1 := range candidates {
+        others := bySize[only1[rel1].Size()]
+        if len(others) == 0 {
+            continue
+        }
+        sort.Strings(others)
+
+        sum1, err := hashFile(filepath.Join(dir1, rel1))
+        if err != nil {
+            return nil, err
+        }
+        for _, rel2 := range others {
+            if paired[rel2] {
+                continue
+            }
+            sum2, err := hashFile(filepath.Join(dir2, rel2))
+            if err != nil {
+                return nil, err
+            }
+            if sum1 == sum2 {
+                moves = append(moves, move{From: rel1, To: rel2})
+                paired[rel2] = true
+                break
+            }
+        }
+    }
+    return moves, nil
+}
+
+// listFiles returns every file below dir that filter lets through, by path
+// relative to dir. Symbolic links are listed as files of their own. When
+// dir is a snapshot its files are listed as they were recorded.
+func listFiles(dir string, filter filepathlengthsorter.Filter) (map[string]os.FileInfo, error) {
+    if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+        return listSnapshot(dir, filter)
+    }
+
+    files := make(map[string]os.FileInfo)
+    err := filter.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+        if err != nil {
+            return err
+        }
+        if !d.IsDir() {
+            relPath, err := filepath.Rel(dir, path)
+            if err != nil {
+                return err
+            }
+            info, err := d.Info()
+            if err != nil {
+                return err
+            }
+            files[relPath] = info
+        }
+        return nil
+    })
+    return files, err
+}
+
+// listSnapshot returns the files recorded in the snapshot at path that
+// filter lets through, like listFiles, and puts their hashes in hashCache
+// under path joined with theirs
+func listSnapshot(path string, filter filepathlengthsorter.Filter) (map[string]os.FileInfo, error) {
+    entries, err := filepathlengthsorter.ReadSnapshot(path)
+    if err != nil {
+        return nil, err
+    }
+    files := make(map[string]os.FileInfo)
+    for _, entry := range entries {
+        info := entry.Info()
+        if !filter.Wants(entry.Path, info) {
+            continue
+        }
+        rel := filepath.FromSlash(entry.Path)
+        files[rel] = info
+        hashCache[filepath.Join(path, rel)] = entry.SHA256
+    }
+    return files, nil
+}
+
+// matching decides which paths in the two trees are taken to be the same
+// file. With all options off only identical paths match.
+type matching struct {
+    IgnoreCase bool // "Report.TXT" matches "report.txt"
+    Unicode    bool // composed and decomposed forms (NFC and NFD) match
+    Separators bool // "\" in a name matches "/", for trees copied from Windows
+}
+
+// key returns what rel is matched by
+func (m matching) key(rel string) string {
+    rel = filepath.ToSlash(rel)
+    if m.Separators {
+        rel = strings.ReplaceAll(rel, `\`, "/")
+    }
+    if m.Unicode {
+        rel = norm.NFC.String(rel)
+    }
+    if m.IgnoreCase {
+        rel = strings.ToLower(rel)
+    }
+    return rel
+}
+
+// keys maps the key of every file in files to its path. When files in one
+// tree share a key, the first in sorted order gets it and the others only
+// match themselves, with a warning on stderr.
+func (m matching) keys(dir string, files map[string]os.FileInfo) map[string]string {
+    keys := make(map[string]string)
+    for _, file := range sortedKeys(files) {
+        key := m.key(file)
+        if other, ok := keys[key]; ok {
+            fmt.Fprintf(os.Stderr, "Warning: %s and %s in %s are the same name once normalized\n", other, file, dir)
+            key = "\x00" + file
+        }
+        keys[key] = file
+    }
+    return keys
+}
+
+// patternList collects the values of a repeatable -exclude flag
+type patternList []filepathlengthsorter.Pattern
+
+func (l *patternList) String() string {
+    return fmt.Sprint(len(*l), " patterns")
+}
+
+func (l *patternList) Set(value string) error {
+    p, err := filepathlengthsorter.ParsePattern(value)
+    if err != nil {
+        return err
+    }
+    *l = append(*l, p)
+    return nil
+}
+
+// ignoreFileList loads the patterns of every -ignore-file flag into a
+// patternList
+type ignoreFileList struct {
+    patterns *patternList
+}
+
+func (l ignoreFileList) String() string {
+    return ""
+}
+
+func (l ignoreFileList) Set(path string) error {
+    patterns, err := filepathlengthsorter.LoadIgnoreFile(path)
+    if err != nil {
+        return err
+    }
+    *l.patterns = append(*l.patterns, patterns...)
+    return nil
+}
+
+// compareDirs compares the files listed in dir1 and dir2. Files that could
+// not be compared are reported on stderr and counted in Errors; the error
+// returned is from detecting moves.
+func compareDirs(dir1, dir2 string, files1, files2 map[string]os.FileInfo, match matching, mode string, tolerance time.Duration, detect bool) (comparison, error) {
+    // Split the files into those on one side only and those on both. Files
+    // in both are known by their path in dir1, and paired with theirs in
+    // dir2.
+    keys1 := match.keys(dir1, files1)
+    keys2 := match.keys(dir2, files2)
+    only1 := make(map[string]os.FileInfo)
+    only2 := make(map[string]os.FileInfo)
+    var common []string
+    paired := make(map[string]string)
+    for key, file := range keys1 {
+        if file2, ok := keys2[key]; ok {
+            common = append(common, file)
+            paired[file] = file2
+        } else {
+            only1[file] = files1[file]
+        }
+    }
+    for key, file := range keys2 {
+        if _, ok := keys1[key]; !ok {
+            only2[file] = files2[file]
+        }
+    }
+
+    // Files that only changed place are reported as moves instead
+    var moves []move
+    if detect {
+        var err error
+        moves, err = detectMoves(dir1, dir2, only1, only2)
+        if err != nil {
+            return comparison{}, err
+        }
+        for _, m := range moves {
+            delete(only1, m.From)
+            delete(only2, m.To)
+        }
+        if moves == nil {
+            moves = []move{}
+        }
+    }
+
+    // Classify the files present in both
+    result := comparison{Dir1: dir1, Dir2: dir2, Mode: mode, Only1: sortedKeys(only1), Only2: sortedKeys(only2), Moves: moves}
+    sort.Strings(common)
+    for _, file := range common {
+        status := statusIdentical
+        if mode != modeNames {
+            var err error
+            status, err = compareFiles(filepath.Join(dir1, file), filepath.Join(dir2, paired[file]), files1[file], files2[paired[file]], mode, tolerance)
+            if err != nil {
+                fmt.Fprintf(os.Stderr, "Error comparing %s: %v\n", file, err)
+                result.Errors++
+                continue
+            }
+        }
+        entry := commonFile{Path: file, Status: status}
+        if paired[file] != file {
+            entry.Path2 = paired[file]
+        }
+        result.Common = append(result.Common, entry)
+    }
+    return result, nil
+}
+
+func main() {
+    if len(os.Args) > 1 && os.Args[1] == "sync" {
+        os.Exit(runSync(os.Args[2:]))
+    }
+    if len(os.Args) > 1 && os.Args[1] == "snapshot" {
+        os.Exit(runSnapshot(os.Args[2:]))
+    }
+
+    mode := flag.String("mode", modeNames, "How to compare files present in both directories: names, size, mtime or hash")
+    detect := flag.Bool("detect-moves", false, "Match files only on one side with files of the same content on the other")
+    tolerance := flag.Duration("mtime-tolerance", 2*time.Second, "Largest modification time difference still counted as equal")
+    format := flag.String("format", formatText, "Output format: text, tree, json, csv or patch")
+    base := flag.String("base", "", "Compare <dir1> and <dir2> as changed copies of this directory, reporting conflicting changes")
+    var match matching
+    flag.BoolVar(&match.IgnoreCase, "ignore-case", false, "Match paths that differ only in case")
+    flag.BoolVar(&match.Unicode, "normalize-unicode", false, "Match paths that differ only in Unicode normalization (NFC and NFD, as from macOS)")
+    flag.BoolVar(&match.Separators, "normalize-separators", false, `Match "\" in names with "/", for trees copied from Windows`)
+    var exclude patternList
+    flag.Var(&exclude, "exclude", "Skip files and directories matching this glob or re:<regexp> in both trees (repeatable)")
+    flag.Var(ignoreFileList{&exclude}, "ignore-file", "Skip what this .gitignore-style file lists in both trees (repeatable)")
+    flag.Parse()
+
+    if flag.NArg() < 2 || (*base != "" && flag.NArg() != 2) {
+        fmt.Fprintln(os.Stderr, "Usage: compare-directories-files [-base dir] [-mode names|size|mtime|hash] [-detect-moves] [-format text|tree|json|csv|patch] [-ignore-case] [-normalize-unicode] [-normalize-separators] [-exclude pattern] <dir1> <dir2> [<dir3>...]")
+        os.Exit(exitTrouble)
+    }
+    switch *mode {
+    case modeNames, modeSize, modeMtime, modeHash:
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown mode %q\n", *mode)
+        os.Exit(exitTrouble)
+    }
+    switch *format {
+    case formatText, formatTree, formatJSON, formatCSV, formatPatch:
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown format %q\n", *format)
+        os.Exit(exitTrouble)
+    }
+
+    // Matrices and three-way comparisons have no moves, trees or patches
+    filter := filepathlengthsorter.Filter{Exclude: exclude}
+    if *base != "" || flag.NArg() > 2 {
+        if *detect {
+            fmt.Fprintln(os.Stderr, "-detect-moves only works when comparing two directories")
+            os.Exit(exitTrouble)
+        }
+        if *format == formatTree || *format == formatPatch {
+            fmt.Fprintf(os.Stderr, "-format %s only works when comparing two directories\n", *format)
+            os.Exit(exitTrouble)
+        }
+        if *base != "" {
+            os.Exit(compareThreeWay(*base, flag.Arg(0), flag.Arg(1), filter, match, *mode, *tolerance, *format))
+        }
+        os.Exit(comparePresence(flag.Args(), filter, match, *mode, *tolerance, *format))
+    }
+
+    dir1 := flag.Arg(0)
+    dir2 := flag.Arg(1)
+    files1, err := listFiles(dir1, filter)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error walking directory %s: %v\n", dir1, err)
+        os.Exit(exitTrouble)
+    }
+    files2, err := listFiles(dir2, filter)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error walking directory %s: %v\n", dir2, err)
+        os.Exit(exitTrouble)
+    }
+
+    result, err := compareDirs(dir1, dir2, files1, files2, match, *mode, *tolerance, *detect)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error detecting moves: %v\n", err)
+        os.Exit(exitTrouble)
+    }
+
+    switch *format {
+    case formatText:
+        printText(result)
+    case formatTree:
+        printTree(result)
+    case formatJSON:
+        err = writeJSON(os.Stdout, result)
+    case formatCSV:
+        err = writeCSV(os.Stdout, result)
+    case formatPatch:
+        printPatch(result)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+        os.Exit(exitTrouble)
+    }
+
+    switch {
+    case result.Errors > 0:
+        os.Exit(exitTrouble)
+    case result.differs():
+        os.Exit(exitDiffer)
+    }
+}
+
+// comparison is everything found out about two trees. Every list is sorted.
+type comparison struct {
+    Dir1   string       `json:"dir1"`
+    Dir2   string       `json:"dir2"`
+    Mode   string       `json:"mode"`
+    Only1  []string     `json:"only_in_dir1"`
+    Only2  []string     `json:"only_in_dir2"`
+    Moves  []move       `json:"moves,omitempty"` // nil without -detect-moves
+    Common []commonFile `json:"common"`
+    Errors int          `json:"errors"` // files that could not be compared
+}
+
+// commonFile is a file present in both trees and how the two copies compare
+type commonFile struct {
+    Path   string `json:"path"`
+    Path2  string `json:"path_in_dir2,omitempty"` // only when it is spelled differently
+    Status string `json:"status"`
+}
+
+// differs reports whether the trees differ in anything that was compared
+func (c comparison) differs() bool {
+    if len(c.Only1) > 0 || len(c.Only2) > 0 || len(c.Moves) > 0 {
+        return true
+    }
+    for _, file := range c.Common {
+        if file.Status != statusIdentical {
+            return true
+        }
+    }
+    return false
+}
+
+// sortedKeys returns the paths in files in sorted order
+func sortedKeys(files map[string]os.FileInfo) []string {
+    keys := make([]string, 0, len(files))
+    for file := range files {
+        keys = append(keys, file)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// printText prints a section per kind of difference and a summary
+func printText(c comparison) {
+    fmt.Printf("\nFiles only in %s:\n", c.Dir1)
+    for _, file := range c.Only1 {
+        fmt.Println(file)
+    }
+
+    fmt.Printf("\nFiles only in %s:\n", c.Dir2)
+    for _, file := range c.Only2 {
+        fmt.Println(file)
+    }
+
+    if c.Moves != nil {
+        fmt.Printf("\nMoved or renamed:\n")
+        for _, m := range c.Moves {
+            fmt.Printf("%s -> %s\n", m.From, m.To)
+        }
+    }
+
+    counts := make(map[string]int)
+    if c.Mode != modeNames {
+        fmt.Printf("\nFiles that differ:\n")
+        for _, file := range c.Common {
+            counts[file.Status]++
+            if file.Status == statusIdentical {
+                continue
+            }
+            fmt.Printf("%s: %s\n", file.Status, file.Path)
+            if file.Path2 != "" {
+                fmt.Printf("  (%s in %s)\n", file.Path2, c.Dir2)
+            }
+        }
+    }
+
+    // Print summary
+    fmt.Printf("\nSummary:\n")
+    fmt.Printf("Files only in %s: %d\n", c.Dir1, len(c.Only1))
+    fmt.Printf("Files only in %s: %d\n", c.Dir2, len(c.Only2))
+    fmt.Printf("Total unique files: %d\n", len(c.Only1)+len(c.Only2))
+    if c.Moves != nil {
+        fmt.Printf("Moved or renamed: %d\n", len(c.Moves))
+    }
+    if c.Mode != modeNames {
+        fmt.Printf("Files in both: %d\n", len(c.Common))
+        for _, status := range []string{statusIdentical, statusSize, statusMtime, statusContent} {
+            if (status == statusMtime && c.Mode != modeMtime) || (status == statusContent && c.Mode != modeHash) {
+                continue
+            }
+            fmt.Printf("  %s: %d\n", status, counts[status])
+        }
+    }
+}
+
+// change is one line of the patch and tree formats: how a path changed
+// going from dir1 to dir2
+type change struct {
+    marker string // "+" only in dir2, "-" only in dir1, "M" differs, "R" moved, " " the same
+    status string
+    path   string
+    from   string // where a moved file was in dir1
+    path2  string // how a file in both is spelled in dir2, when not like path
+}
+
+// changes lists every difference sorted by path. Files that are the same on
+// both sides are only included when all is set.
+func changes(c comparison, all bool) []change {
+    var list []change
+    for _, file := range c.Only1 {
+        list = append(list, change{marker: "-", status: statusOnly1, path: file})
+    }
+    for _, file := range c.Only2 {
+        list = append(list, change{marker: "+", status: statusOnly2, path: file})
+    }
+    for _, m := range c.Moves {
+        list = append(list, change{marker: "R", status: statusMoved, path: m.To, from: m.From})
+    }
+    for _, file := range c.Common {
+        if file.Status != statusIdentical {
+            list = append(list, change{marker: "M", status: file.Status, path: file.Path, path2: file.Path2})
+        } else if all {
+            list = append(list, change{marker: " ", status: file.Status, path: file.Path, path2: file.Path2})
+        }
+    }
+    sort.Slice(list, func(i, j int) bool { return list[i].path < list[j].path })
+    return list
+}
+
+// printPatch prints a "+ path", "- path", "M path" or "R from -> to" line
+// per difference
+func printPatch(c comparison) {
+    for _, ch := range changes(c, false) {
+        if ch.marker == "R" {
+            fmt.Printf("R %s -> %s\n", ch.from, ch.path)
+            continue
+        }
+        fmt.Printf("%s %s\n", ch.marker, ch.path)
+    }
+}
+
+// printTree prints both trees merged into one, every file marked the way
+// printPatch marks it and unchanged files left blank
+func printTree(c comparison) {
+    var previous []string
+    for _, ch := range changes(c, true) {
+        parts := strings.Split(ch.path, string(filepath.Separator))
+        dirs := parts[:len(parts)-1]
+
+        // Print the directories not shared with the previous file
+        shared := 0
+        for shared < len(dirs) && shared < len(previous) && dirs[shared] == previous[shared] {
+            shared++
+        }
+        for depth := shared; depth < len(dirs); depth++ {
+            fmt.Printf("%s%s/\n", strings.Repeat("  ", depth+1), dirs[depth])
+        }
+        previous = dirs
+
+        name := parts[len(parts)-1]
+        if ch.marker == "R" {
+            name += " (from " + ch.from + ")"
+        }
+        fmt.Printf("%s %s%s\n", ch.marker, strings.Repeat("  ", len(dirs)), name)
+    }
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v any) error {
+    enc := json.NewEncoder(w)
+    enc.SetIndent("", "  ")
+    return enc.Encode(v)
+}
+
+// writeCSV writes a status,path,new_path row per file. The new_path is
+// where a moved file went, or how a file in both trees is spelled in dir2
+// when that differs.
+func writeCSV(w io.Writer, c comparison) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"status", "path", "new_path"})
+    for _, ch := range changes(c, true) {
+        if ch.marker == "R" {
+            cw.Write([]string{ch.status, ch.from, ch.path})
+            continue
+        }
+        cw.Write([]string{ch.status, ch.path, ch.path2})
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// throttle keeps the copies of a sync below a number of bytes per second
+// on average, by sleeping after each chunk until the bytes copied so far
+// are due
+type throttle struct {
+    ctx   context.Context
+    rate  int64 // bytes per second, 0 for no limit
+    start time.Time
+    total int64
+}
+
+// wait accounts for n more bytes and sleeps until they are due. It returns
+// early when ctx is cancelled; the next read then fails.
+func (t *throttle) wait(n int) {
+    if t.rate <= 0 {
+        return
+    }
+    if t.start.IsZero() {
+        t.start = time.Now()
+    }
+    t.total += int64(n)
+    due := t.start.Add(time.Duration(float64(t.total) / float64(t.rate) * float64(time.Second)))
+    timer := time.NewTimer(time.Until(due))
+    defer timer.Stop()
+    select {
+    case <-timer.C:
+    case <-t.ctx.Done():
+    }
+}
+
+// parseRate parses a rate in bytes per second such as 500K, 10M or 1G
+// (binary multiples). 0 means no limit.
+func parseRate(s string) (int64, error) {
+    s = strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+    multiplier := int64(1)
+    for i, suffix := range []string{"K", "M", "G"} {
+        if rest, ok := strings.CutSuffix(s, suffix); ok {
+            s = rest
+            multiplier = 1 << (10 * (i + 1))
+            break
+        }
+    }
+    n, err := strconv.ParseFloat(s, 64)
+    if err != nil || n < 0 {
+        return 0, fmt.Errorf("invalid rate %q", s)
+    }
+    return int64(n * float64(multiplier)), nil
+}
+
+// syncOptions controls how files are copied by sync
+type syncOptions struct {
+    Verify        bool // compare SHA-256 checksums of each copy
+    PreserveTimes bool
+    PreservePerms bool
+    limit         *throttle
+}
+
+// syncFile copies src over dst the way MoveLongPaths copies a file to
+// another filesystem, with CopyVerified
+func syncFile(ctx context.Context, src, dst string, opts syncOptions) error {
+    info, err := os.Stat(src)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+        return fmt.Errorf("creating directory: %w", err)
+    }
+
+    _, err = filepathlengthsorter.CopyVerified(ctx, src, dst, info, filepathlengthsorter.CopyOptions{
+        Verify:    opts.Verify,
+        SkipMode:  !opts.PreservePerms,
+        SkipTimes: !opts.PreserveTimes,
+        OnCopy:    opts.limit.wait,
+    })
+    return err
+}
+
+// removeExtra deletes the file rel from dir2, then the directories above it
+// that do not exist in dir1 once they are empty
+func removeExtra(dir1, dir2, rel string) error {
+    if err := os.Remove(filepath.Join(dir2, rel)); err != nil {
+        return err
+    }
+    for dir := filepath.Dir(rel); dir != "."; dir = filepath.Dir(dir) {
+        if _, err := os.Stat(filepath.Join(dir1, dir)); err == nil {
+            break
+        }
+        if os.Remove(filepath.Join(dir2, dir)) != nil {
+            break
+        }
+    }
+    return nil
+}
+
+// runSync implements the sync command, which makes dir2 mirror dir1: files
+// missing from dir2 are copied, files that differ are replaced and, with
+// -delete, files only in dir2 are removed
+func runSync(args []string) int {
+    flags := flag.NewFlagSet("sync", flag.ExitOnError)
+    dryRun := flags.Bool("dry-run", false, "Only list what would be copied, replaced and deleted")
+    remove := flags.Bool("delete", false, "Delete files that are only in dir2")
+    mode := flags.String("mode", modeMtime, "How to decide a file has changed: size, mtime or hash")
+    tolerance := flags.Duration("mtime-tolerance", 2*time.Second, "Largest modification time difference still counted as equal")
+    verify := flags.Bool("verify", false, "Compare SHA-256 checksums of each copy before putting it in place")
+    bwlimit := flags.String("bwlimit", "0", "Most bytes per second to copy, such as 500K or 10M (0 for no limit)")
+    preserveTimes := flags.Bool("preserve-times", true, "Give copies the modification time of the original (needed for -mode mtime to see them as unchanged)")
+    preservePerms := flags.Bool("preserve-perms", true, "Give copies the permissions of the original")
+    flags.Parse(args)
+
+    if flags.NArg() != 2 {
+        fmt.Fprintln(os.Stderr, "Usage: compare-directories-files sync [-dry-run] [-delete] [-mode size|mtime|hash] [-verify] [-bwlimit rate] <dir1> <dir2>")
+        return exitTrouble
+    }
+    switch *mode {
+    case modeSize, modeMtime, modeHash:
+    default:
+        fmt.Fprintf(os.Stderr, "Unknown mode %q\n", *mode)
+        return exitTrouble
+    }
+    rate, err := parseRate(*bwlimit)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return exitTrouble
+    }
+
+    // The first interrupt stops after removing the copy in progress
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    dir1 := flags.Arg(0)
+    dir2 := flags.Arg(1)
+    for _, dir := range []string{dir1, dir2} {
+        if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+            fmt.Fprintf(os.Stderr, "%s is not a directory, sync cannot use snapshots\n", dir)
+            return exitTrouble
+        }
+    }
+    files1, err := listFiles(dir1, filepathlengthsorter.Filter{})
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error walking directory %s: %v\n", dir1, err)
+        return exitTrouble
+    }
+    // A dir2 that does not exist yet is empty
+    files2, err := listFiles(dir2, filepathlengthsorter.Filter{})
+    if errors.Is(err, fs.ErrNotExist) {
+        files2, err = map[string]os.FileInfo{}, nil
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error walking directory %s: %v\n", dir2, err)
+        return exitTrouble
+    }
+
+    result, err := compareDirs(dir1, dir2, files1, files2, matching{}, *mode, *tolerance, false)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error comparing: %v\n", err)
+        return exitTrouble
+    }
+    errorCount := result.Errors
+
+    opts := syncOptions{
+        Verify:        *verify,
+        PreserveTimes: *preserveTimes,
+        PreservePerms: *preservePerms,
+        limit:         &throttle{ctx: ctx, rate: rate},
+    }
+
+    // Go through the differences in path order, printing each as it is
+    // applied: "+" copied, "M" replaced, "-" deleted
+    var copied, replaced, deleted int
+    var bytes int64
+    for _, ch := range changes(result, false) {
+        if ctx.Err() != nil {
+            fmt.Fprintln(os.Stderr, "Interrupted")
+            errorCount++
+            break
+        }
+
+        // The markers of changes describe dir2 against dir1, so a file
+        // only in dir1 is added to dir2 and one only in dir2 is deleted
+        marker := ch.marker
+        switch ch.marker {
+        case "-":
+            marker = "+"
+        case "+":
+            if !*remove {
+                continue
+            }
+            marker = "-"
+        }
+
+        err = nil
+        if !*dryRun && marker == "-" {
+            err = removeExtra(dir1, dir2, ch.path)
+        } else if !*dryRun {
+            err = syncFile(ctx, filepath.Join(dir1, ch.path), filepath.Join(dir2, ch.path), opts)
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error syncing %s: %v\n", ch.path, err)
+            errorCount++
+            continue
+        }
+
+        fmt.Printf("%s %s\n", marker, ch.path)
+        switch marker {
+        case "+":
+            copied++
+            bytes += files1[ch.path].Size()
+        case "M":
+            replaced++
+            bytes += files1[ch.path].Size()
+        case "-":
+            deleted++
+        }
+    }
+
+    verb := "Copied %d, replaced %d and deleted %d files, %s in all\n"
+    if *dryRun {
+        verb = "Dry run: would copy %d, replace %d and delete %d files, %s in all\n"
+    }
+    fmt.Printf(verb, copied, replaced, deleted, filepathlengthsorter.FormatFileSize(bytes))
+    if errorCount > 0 {
+        fmt.Fprintf(os.Stderr, "%d files could not be synced\n", errorCount)
+        return exitTrouble
+    }
+    return 0
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    "filepathlengthsorter"
+)
+
+// How a copy compares in the presence matrix, besides the statuses of
+// compareFiles
+const (
+    statusPresent = "present" // the copy the others are compared with, or any copy with -mode names
+    statusMissing = "missing"
+    statusError   = "error" // could not be compared
+)
+
+// tree is a directory given on the command line with its files
+type tree struct {
+    dir   string
+    files map[string]os.FileInfo
+    keys  map[string]string // see matching.keys
+}
+
+// loadTree lists the files in dir and how match knows them
+func loadTree(dir string, filter filepathlengthsorter.Filter, match matching) (tree, error) {
+    files, err := listFiles(dir, filter)
+    if err != nil {
+        return tree{}, err
+    }
+    return tree{dir: dir, files: files, keys: match.keys(dir, files)}, nil
+}
+
+// loadTrees loads every directory in dirs, reporting those it cannot walk
+// on stderr
+func loadTrees(dirs []string, filter filepathlengthsorter.Filter, match matching) ([]tree, bool) {
+    var trees []tree
+    for _, dir := range dirs {
+        t, err := loadTree(dir, filter, match)
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error walking directory %s: %v\n", dir, err)
+            return nil, false
+        }
+        trees = append(trees, t)
+    }
+    return trees, true
+}
+
+// compareCopies compares the files the two trees have under key, which both
+// must have
+func compareCopies(a, b tree, key, mode string, tolerance time.Duration) (string, error) {
+    fileA, fileB := a.keys[key], b.keys[key]
+    return compareFiles(filepath.Join(a.dir, fileA), filepath.Join(b.dir, fileB), a.files[fileA], b.files[fileB], mode, tolerance)
+}
+
+// allKeys returns the keys of every file in any of trees, sorted
+func allKeys(trees []tree) []string {
+    seen := make(map[string]bool)
+    var keys []string
+    for _, t := range trees {
+        for key := range t.keys {
+            if !seen[key] {
+                seen[key] = true
+                keys = append(keys, key)
+            }
+        }
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// presence is which file exists in which of several trees. Every list is
+// sorted.
+type presence struct {
+    Dirs   []string      `json:"dirs"`
+    Mode   string        `json:"mode"`
+    Files  []presenceRow `json:"files"`
+    Errors int           `json:"errors"` // files that could not be compared
+}
+
+// presenceRow is one file and, per tree, whether it is there. The first
+// tree that has the file holds the copy the others are compared with.
+type presenceRow struct {
+    Path string   `json:"path"` // as spelled in the first tree that has it
+    In   []string `json:"in"`   // a status per tree, in the order of Dirs
+}
+
+// complete reports whether every tree has the same copy of the file
+func (r presenceRow) complete() bool {
+    for _, status := range r.In {
+        if status != statusPresent && status != statusIdentical {
+            return false
+        }
+    }
+    return true
+}
+
+// differs reports whether any file is missing from or differs in a tree
+func (p presence) differs() bool {
+    for _, row := range p.Files {
+        if !row.complete() {
+            return true
+        }
+    }
+    return false
+}
+
+// presenceMatrix finds out which of trees has which file. Files that could
+// not be compared are reported on stderr and counted in Errors.
+func presenceMatrix(trees []tree, mode string, tolerance time.Duration) presence {
+    result := presence{Mode: mode, Files: []presenceRow{}}
+    for _, t := range trees {
+        result.Dirs = append(result.Dirs, t.dir)
+    }
+
+    for _, key := range allKeys(trees) {
+        row := presenceRow{In: make([]string, len(trees))}
+        first := -1
+        for i, t := range trees {
+            file, ok := t.keys[key]
+            switch {
+            case !ok:
+                row.In[i] = statusMissing
+            case first < 0:
+                first = i
+                row.Path = file
+                row.In[i] = statusPresent
+            case mode == modeNames:
+                row.In[i] = statusPresent
+            default:
+                status, err := compareCopies(trees[first], t, key, mode, tolerance)
+                if err != nil {
+                    fmt.Fprintf(os.Stderr, "Error comparing %s in %s: %v\n", file, t.dir, err)
+                    result.Errors++
+                    status = statusError
+                }
+                row.In[i] = status
+            }
+        }
+        result.Files = append(result.Files, row)
+    }
+    sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Path < result.Files[j].Path })
+    return result
+}
+
+// matrixMarker is how printMatrix shows a status
+func matrixMarker(status string) string {
+    switch status {
+    case statusPresent:
+        return "x"
+    case statusIdentical:
+        return "="
+    case statusMissing:
+        return "-"
+    case statusError:
+        return "?"
+    default:
+        return "M"
+    }
+}
+
+// printMatrix prints a column per tree and a row per file that is not the
+// same in all of them, then a summary
+func printMatrix(p presence) {
+    fmt.Printf("\nTrees:\n")
+    for i, dir := range p.Dirs {
+        fmt.Printf("%d: %s\n", i+1, dir)
+    }
+
+    // Columns are as wide as the tree numbers, so they stay aligned past 9
+    width := len(fmt.Sprint(len(p.Dirs)))
+    header := make([]string, len(p.Dirs))
+    for i := range p.Dirs {
+        header[i] = fmt.Sprintf("%*d", width, i+1)
+    }
+    fmt.Printf("\nFiles not the same in every tree (x present, = same as the first x, M differs, - missing):\n")
+    fmt.Printf("%s\n", strings.Join(header, " "))
+
+    var incomplete int
+    missing := make([]int, len(p.Dirs))
+    for _, row := range p.Files {
+        if row.complete() {
+            continue
+        }
+        incomplete++
+        cells := make([]string, len(row.In))
+        for i, status := range row.In {
+            cells[i] = fmt.Sprintf("%*s", width, matrixMarker(status))
+            if status == statusMissing {
+                missing[i]++
+            }
+        }
+        fmt.Printf("%s  %s\n", strings.Join(cells, " "), row.Path)
+    }
+
+    // Print summary
+    fmt.Printf("\nSummary:\n")
+    fmt.Printf("Total files: %d\n", len(p.Files))
+    fmt.Printf("The same in every tree: %d\n", len(p.Files)-incomplete)
+    fmt.Printf("Missing from or different in some tree: %d\n", incomplete)
+    for i, dir := range p.Dirs {
+        fmt.Printf("  missing from %s: %d\n", dir, missing[i])
+    }
+}
+
+// writeMatrixCSV writes a path row per file with a status column per tree
+func writeMatrixCSV(w io.Writer, p presence) error {
+    cw := csv.NewWriter(w)
+    cw.Write(append([]string{"path"}, p.Dirs...))
+    for _, row := range p.Files {
+        cw.Write(append([]string{row.Path}, row.In...))
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// comparePresence compares the trees in dirs and prints the matrix in
+// format. It returns the exit code.
+func comparePresence(dirs []string, filter filepathlengthsorter.Filter, match matching, mode string, tolerance time.Duration, format string) int {
+    trees, ok := loadTrees(dirs, filter, match)
+    if !ok {
+        return exitTrouble
+    }
+
+    result := presenceMatrix(trees, mode, tolerance)
+    var err error
+    switch format {
+    case formatText:
+        printMatrix(result)
+    case formatJSON:
+        err = writeJSON(os.Stdout, result)
+    case formatCSV:
+        err = writeMatrixCSV(os.Stdout, result)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+        return exitTrouble
+    }
+
+    switch {
+    case result.Errors > 0:
+        return exitTrouble
+    case result.differs():
+        return exitDiffer
+    }
+    return 0
+}
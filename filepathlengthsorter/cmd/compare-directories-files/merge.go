@@ -0,0 +1,207 @@
+package main
+
+import (
+    "encoding/csv"
+    "fmt"
+    "io"
+    "os"
+    "sort"
+    "time"
+
+    "filepathlengthsorter"
+)
+
+// How one side of a three-way comparison changed a file from the base
+const (
+    changeAdded    = "added"
+    changeRemoved  = "removed"
+    changeModified = "modified"
+)
+
+// How a file changed in a three-way comparison
+const (
+    mergeLeft     = "changed in left"
+    mergeRight    = "changed in right"
+    mergeBoth     = "changed the same way in both"
+    mergeConflict = "conflict"
+)
+
+// threeWay is everything found out about two trees that both started as
+// copies of a base. Files are sorted and only those that changed listed.
+type threeWay struct {
+    Base   string      `json:"base"`
+    Left   string      `json:"left"`
+    Right  string      `json:"right"`
+    Mode   string      `json:"mode"`
+    Files  []mergeFile `json:"files"`
+    Errors int         `json:"errors"` // files that could not be compared
+}
+
+// mergeFile is a file that changed on at least one side
+type mergeFile struct {
+    Path   string `json:"path"`            // as spelled in the first of base, left and right that has it
+    Left   string `json:"left,omitempty"`  // added, removed, modified or empty
+    Right  string `json:"right,omitempty"` // the same for right
+    Status string `json:"status"`
+}
+
+// sideChange returns how side changed the file under key from base, or ""
+// if it did not
+func sideChange(base, side tree, key, mode string, tolerance time.Duration) (string, error) {
+    _, inBase := base.keys[key]
+    _, inSide := side.keys[key]
+    switch {
+    case !inBase && inSide:
+        return changeAdded, nil
+    case inBase && !inSide:
+        return changeRemoved, nil
+    case !inBase || mode == modeNames:
+        return "", nil
+    }
+    status, err := compareCopies(base, side, key, mode, tolerance)
+    if err != nil || status == statusIdentical {
+        return "", err
+    }
+    return changeModified, nil
+}
+
+// compareMerge classifies the changes left and right made to base. A file
+// both sides changed is a conflict unless both removed it or both ended up
+// with the same copy. Files that could not be compared are reported on
+// stderr and counted in Errors.
+func compareMerge(base, left, right tree, mode string, tolerance time.Duration) threeWay {
+    result := threeWay{Base: base.dir, Left: left.dir, Right: right.dir, Mode: mode, Files: []mergeFile{}}
+    for _, key := range allKeys([]tree{base, left, right}) {
+        file := mergeFile{}
+        for _, t := range []tree{base, left, right} {
+            if path, ok := t.keys[key]; ok {
+                file.Path = path
+                break
+            }
+        }
+
+        var err error
+        if file.Left, err = sideChange(base, left, key, mode, tolerance); err == nil {
+            file.Right, err = sideChange(base, right, key, mode, tolerance)
+        }
+        switch {
+        case err != nil:
+        case file.Left == "" && file.Right == "":
+            continue
+        case file.Right == "":
+            file.Status = mergeLeft
+        case file.Left == "":
+            file.Status = mergeRight
+        case file.Left == changeRemoved && file.Right == changeRemoved:
+            file.Status = mergeBoth
+        case file.Left == changeRemoved || file.Right == changeRemoved:
+            file.Status = mergeConflict
+        default:
+            // With -mode names two copies can only be told apart by absence
+            status := statusIdentical
+            if mode != modeNames {
+                status, err = compareCopies(left, right, key, mode, tolerance)
+            }
+            file.Status = mergeConflict
+            if status == statusIdentical {
+                file.Status = mergeBoth
+            }
+        }
+        if err != nil {
+            fmt.Fprintf(os.Stderr, "Error comparing %s: %v\n", file.Path, err)
+            result.Errors++
+            continue
+        }
+        result.Files = append(result.Files, file)
+    }
+    sort.Slice(result.Files, func(i, j int) bool { return result.Files[i].Path < result.Files[j].Path })
+    return result
+}
+
+// describe says how both sides changed the file, for example "modified in
+// left, removed in right"
+func (f mergeFile) describe() string {
+    switch {
+    case f.Right == "":
+        return f.Left + " in left"
+    case f.Left == "":
+        return f.Right + " in right"
+    case f.Left == f.Right:
+        return f.Left + " in both"
+    }
+    return f.Left + " in left, " + f.Right + " in right"
+}
+
+// printMerge prints a section per status and a summary
+func printMerge(m threeWay) {
+    counts := make(map[string]int)
+    for _, status := range []string{mergeLeft, mergeRight, mergeBoth, mergeConflict} {
+        switch status {
+        case mergeLeft:
+            fmt.Printf("\nChanged only in %s:\n", m.Left)
+        case mergeRight:
+            fmt.Printf("\nChanged only in %s:\n", m.Right)
+        case mergeBoth:
+            fmt.Printf("\nChanged the same way in both:\n")
+        case mergeConflict:
+            fmt.Printf("\nConflicts:\n")
+        }
+        for _, file := range m.Files {
+            if file.Status != status {
+                continue
+            }
+            counts[status]++
+            fmt.Printf("%s (%s)\n", file.Path, file.describe())
+        }
+    }
+
+    // Print summary
+    fmt.Printf("\nSummary:\n")
+    fmt.Printf("Changed only in %s: %d\n", m.Left, counts[mergeLeft])
+    fmt.Printf("Changed only in %s: %d\n", m.Right, counts[mergeRight])
+    fmt.Printf("Changed the same way in both: %d\n", counts[mergeBoth])
+    fmt.Printf("Conflicts: %d\n", counts[mergeConflict])
+}
+
+// writeMergeCSV writes a status,path,left,right row per changed file
+func writeMergeCSV(w io.Writer, m threeWay) error {
+    cw := csv.NewWriter(w)
+    cw.Write([]string{"status", "path", "left", "right"})
+    for _, file := range m.Files {
+        cw.Write([]string{file.Status, file.Path, file.Left, file.Right})
+    }
+    cw.Flush()
+    return cw.Error()
+}
+
+// compareThreeWay compares left and right against base and prints the
+// changes in format. It returns the exit code.
+func compareThreeWay(base, left, right string, filter filepathlengthsorter.Filter, match matching, mode string, tolerance time.Duration, format string) int {
+    trees, ok := loadTrees([]string{base, left, right}, filter, match)
+    if !ok {
+        return exitTrouble
+    }
+
+    result := compareMerge(trees[0], trees[1], trees[2], mode, tolerance)
+    var err error
+    switch format {
+    case formatText:
+        printMerge(result)
+    case formatJSON:
+        err = writeJSON(os.Stdout, result)
+    case formatCSV:
+        err = writeMergeCSV(os.Stdout, result)
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+        return exitTrouble
+    }
+
+    switch {
+    case result.Errors > 0:
+        return exitTrouble
+    case len(result.Files) > 0:
+        return exitDiffer
+    }
+    return 0
+}
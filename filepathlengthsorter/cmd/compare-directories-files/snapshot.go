@@ -0,0 +1,66 @@
+package main
+
+import (
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "flag"
+    "fmt"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "time"
+
+    "filepathlengthsorter"
+)
+
+// runSnapshot implements the snapshot command and returns the exit code
+func runSnapshot(args []string) int {
+    flags := flag.NewFlagSet("snapshot", flag.ExitOnError)
+    hash := flags.Bool("hash", false, "Also record the SHA-256 of every file, so the snapshot can be compared with -mode hash")
+    output := flags.String("o", "", "Where to write the snapshot (default snapshot-<timestamp>.jsonl.gz)")
+    var exclude patternList
+    flags.Var(&exclude, "exclude", "Leave out files and directories matching this glob or re:<regexp> (repeatable)")
+    flags.Var(ignoreFileList{&exclude}, "ignore-file", "Leave out what this .gitignore-style file lists (repeatable)")
+    flags.Parse(args)
+
+    if flags.NArg() != 1 {
+        fmt.Fprintln(os.Stderr, "Usage: compare-directories-files snapshot [-hash] [-o file] [-exclude pattern] <dir>")
+        return exitTrouble
+    }
+    dir := flags.Arg(0)
+    if *output == "" {
+        *output = fmt.Sprintf("snapshot-%s.jsonl.gz", time.Now().Format("20060102-150405"))
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+    defer stop()
+
+    // Write next to the destination and rename when done, so an interrupted
+    // run never leaves a truncated snapshot behind. The temporary name is
+    // random and must not exist yet, so nothing there is overwritten, and
+    // the file gets the usual permissions with the umask applied.
+    token := make([]byte, 4)
+    rand.Read(token)
+    partial := filepath.Join(filepath.Dir(*output), "."+filepath.Base(*output)+"."+hex.EncodeToString(token)+filepathlengthsorter.PartialSuffix)
+    file, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+        return exitTrouble
+    }
+    count, err := filepathlengthsorter.WriteSnapshot(ctx, file, dir, filepathlengthsorter.Filter{Exclude: exclude}, *hash)
+    if closeErr := file.Close(); err == nil {
+        err = closeErr
+    }
+    if err == nil {
+        err = os.Rename(partial, *output)
+    }
+    if err != nil {
+        os.Remove(partial)
+        fmt.Fprintf(os.Stderr, "Error taking snapshot of %s: %v\n", dir, err)
+        return exitTrouble
+    }
+
+    fmt.Printf("Recorded %d files of %s in %s\n", count, dir, *output)
+    return 0
+}
@@ -0,0 +1,436 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// What ResolveDuplicates does with every copy but the first of a group
+const (
+	// DuplicatesHardlink replaces each copy with a hard link to the first,
+	// so the content is only stored once
+	DuplicatesHardlink = "hardlink"
+	// DuplicatesQuarantine moves each copy into a quarantine directory,
+	// keeping its path relative to the root it was found in
+	DuplicatesQuarantine = "quarantine"
+)
+
+// partialHashSize is how much of the start of a file is hashed to tell
+// apart files of the same size before hashing them in full
+const partialHashSize = 64 << 10
+
+// DuplicateGroup is a set of files with the same content
+type DuplicateGroup struct {
+	Size     int64
+	Checksum string   // SHA-256 of the content
+	Paths    []string // the first is the one kept
+}
+
+// Wasted returns the space taken by every copy but the first
+func (g DuplicateGroup) Wasted() int64 {
+	return g.Size * int64(len(g.Paths)-1)
+}
+
+// scannedFile is a candidate found while walking the roots
+type scannedFile struct {
+	path string
+	info os.FileInfo
+}
+
+// FindDuplicates walks roots and groups the regular files with the same
+// content. Files are grouped by size first, then by a hash of their first
+// 64 KiB and only then by a hash of all of their content, so most files
+// are never read. Empty files and files that are already hard links to
+// each other are not reported. Within a group files are listed in walk
+// order, roots in the order given, so the file kept is from the earliest
+// root. Groups are sorted by wasted space, largest first.
+func FindDuplicates(ctx context.Context, roots []string, filter Filter) ([]DuplicateGroup, []FileMoveError) {
+	var errorFiles []FileMoveError
+
+	// Group by size, visiting a path only once when roots overlap
+	bySize := make(map[int64][]scannedFile)
+	var sizes []int64
+	seen := make(map[string]bool)
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: root, Error: err})
+			continue
+		}
+		err = filter.WalkDir(absRoot, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+				return nil // Continue walking
+			}
+			if ctx.Err() != nil {
+				return filepath.SkipAll
+			}
+			if seen[path] || d.IsDir() {
+				return nil
+			}
+			seen[path] = true
+
+			info, err := d.Info()
+			if err != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+				return nil
+			}
+			if !info.Mode().IsRegular() || info.Size() == 0 {
+				return nil
+			}
+			if bySize[info.Size()] == nil {
+				sizes = append(sizes, info.Size())
+			}
+			bySize[info.Size()] = append(bySize[info.Size()], scannedFile{path, info})
+			return nil
+		})
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: absRoot, Error: err})
+		}
+	}
+
+	var groups []DuplicateGroup
+	for _, size := range sizes {
+		files := distinctFiles(bySize[size])
+		if len(files) < 2 {
+			continue
+		}
+
+		// Small files are read whole by the partial hash already
+		for _, candidates := range groupByHash(ctx, files, partialHash, &errorFiles) {
+			byContent := []hashGroup{candidates}
+			if size > partialHashSize {
+				byContent = groupByHash(ctx, candidates.files, HashFile, &errorFiles)
+			}
+			for _, same := range byContent {
+				group := DuplicateGroup{Size: size, Checksum: same.sum}
+				for _, file := range same.files {
+					group.Paths = append(group.Paths, file.path)
+				}
+				groups = append(groups, group)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: strings.Join(roots, ", "), Error: fmt.Errorf("interrupted while looking for duplicates: %w", ctx.Err())})
+	}
+
+	slices.SortStableFunc(groups, func(a, b DuplicateGroup) int {
+		switch {
+		case a.Wasted() > b.Wasted():
+			return -1
+		case a.Wasted() < b.Wasted():
+			return 1
+		}
+		return strings.Compare(a.Paths[0], b.Paths[0])
+	})
+	return groups, errorFiles
+}
+
+// distinctFiles drops every file that is a hard link to one before it,
+// since linking them again saves nothing
+func distinctFiles(files []scannedFile) []scannedFile {
+	var distinct []scannedFile
+	for _, file := range files {
+		linked := slices.ContainsFunc(distinct, func(other scannedFile) bool {
+			return os.SameFile(file.info, other.info)
+		})
+		if !linked {
+			distinct = append(distinct, file)
+		}
+	}
+	return distinct
+}
+
+// hashGroup is a set of files with the same hash
+type hashGroup struct {
+	sum   string
+	files []scannedFile
+}
+
+// groupByHash splits files by the hash sum returns, keeping the order of
+// files within each group and dropping groups of one. Files that cannot be
+// read are reported in errorFiles and left out.
+func groupByHash(ctx context.Context, files []scannedFile, sum func(string) (string, error), errorFiles *[]FileMoveError) []hashGroup {
+	byHash := make(map[string][]scannedFile)
+	var order []string
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return nil
+		}
+		h, err := sum(file.path)
+		if err != nil {
+			*errorFiles = append(*errorFiles, FileMoveError{Path: file.path, Error: err})
+			continue
+		}
+		if byHash[h] == nil {
+			order = append(order, h)
+		}
+		byHash[h] = append(byHash[h], file)
+	}
+
+	var groups []hashGroup
+	for _, h := range order {
+		if len(byHash[h]) > 1 {
+			groups = append(groups, hashGroup{h, byHash[h]})
+		}
+	}
+	return groups
+}
+
+// partialHash returns the hex SHA-256 of the first partialHashSize bytes
+// of the file at path
+func partialHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.CopyN(digest, file, partialHashSize); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// DuplicateOptions holds the behaviour of ResolveDuplicates
+type DuplicateOptions struct {
+	// Action is one of the Duplicates constants
+	Action string
+	// QuarantineDir is where DuplicatesQuarantine moves copies to
+	QuarantineDir string
+	// Roots are the directories the groups were found in. A quarantined
+	// copy goes under the base name of its root.
+	Roots []string
+	// ManifestPath is where a real run journals every action. No manifest
+	// is written when it is empty.
+	ManifestPath string
+	// Log is where ResolveDuplicates describes what it is doing. Nil means
+	// stdout.
+	Log io.Writer
+}
+
+// DuplicateAction is what was done with one copy, as journalled in the
+// manifest of ResolveDuplicates
+type DuplicateAction struct {
+	Action   string    `json:"action"`
+	Path     string    `json:"path"`               // the copy
+	Kept     string    `json:"kept"`               // the file it duplicated
+	NewPath  string    `json:"new_path,omitempty"` // where a quarantined copy went
+	Size     int64     `json:"size"`
+	Checksum string    `json:"sha256"`
+	At       time.Time `json:"at"` // zero in dry runs
+}
+
+// ResolveDuplicates hard links or quarantines every copy but the first of
+// each group. A copy that is no longer a regular file of the group's size
+// is left alone, and before a copy is replaced by a link both it and the
+// kept file are hashed again. Cancelling ctx stops before the next copy.
+func ResolveDuplicates(ctx context.Context, groups []DuplicateGroup, dryRun bool, opts DuplicateOptions) ([]DuplicateAction, []FileMoveError) {
+	var done []DuplicateAction
+	var errorFiles []FileMoveError
+	log := opts.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	// Journalled like moves are, one line flushed to disk per action
+	var journal *os.File
+	if opts.ManifestPath != "" && !dryRun {
+		var err error
+		journal, err = os.OpenFile(opts.ManifestPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, []FileMoveError{{Path: opts.ManifestPath, Error: err}}
+		}
+		defer journal.Close()
+	}
+
+	roots := quarantineNames(opts.Roots)
+	quarantineDir, err := filepath.Abs(opts.QuarantineDir)
+	if err != nil {
+		return nil, []FileMoveError{{Path: opts.QuarantineDir, Error: err}}
+	}
+	for _, group := range groups {
+		for _, path := range group.Paths[1:] {
+			if ctx.Err() != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: path, Error: fmt.Errorf("interrupted: %w", ctx.Err())})
+				return done, errorFiles
+			}
+
+			action := DuplicateAction{
+				Action:   opts.Action,
+				Path:     path,
+				Kept:     group.Paths[0],
+				Size:     group.Size,
+				Checksum: group.Checksum,
+			}
+			var err error
+			switch opts.Action {
+			case DuplicatesHardlink:
+				err = replaceWithLink(path, group, dryRun)
+			case DuplicatesQuarantine:
+				action.NewPath, err = quarantinePath(path, quarantineDir, roots)
+				if err == nil && !dryRun {
+					err = quarantine(ctx, path, action.NewPath, group)
+				}
+			default:
+				err = fmt.Errorf("unknown action %q", opts.Action)
+			}
+			if err != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+				fmt.Fprintf(log, "Error with %s: %v\n", path, err)
+				continue
+			}
+
+			if !dryRun {
+				action.At = time.Now().UTC()
+				if journal != nil {
+					if err := recordAction(journal, action); err != nil {
+						errorFiles = append(errorFiles, FileMoveError{Path: path, Error: fmt.Errorf("recording in manifest: %w", err)})
+					}
+				}
+			}
+			done = append(done, action)
+			verb, to := "Linked", action.Kept
+			if action.NewPath != "" {
+				verb, to = "Quarantined", action.NewPath
+			}
+			if dryRun {
+				verb = "Would have " + strings.ToLower(verb)
+			}
+			fmt.Fprintf(log, "%s: %s\nTo: %s\n\n", verb, path, to)
+		}
+	}
+	return done, errorFiles
+}
+
+// recordAction appends action to the manifest and flushes it to disk
+func recordAction(journal *os.File, action DuplicateAction) error {
+	if err := json.NewEncoder(journal).Encode(action); err != nil {
+		return err
+	}
+	return journal.Sync()
+}
+
+// unchanged checks that path is still a regular file of the group's size
+func unchanged(path string, group DuplicateGroup) (os.FileInfo, error) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() != group.Size {
+		return nil, errors.New("changed since it was scanned")
+	}
+	return info, nil
+}
+
+// replaceWithLink replaces path with a hard link to the first file of
+// group. The link is made under a temporary name and renamed over path, so
+// path never goes missing.
+func replaceWithLink(path string, group DuplicateGroup, dryRun bool) error {
+	if _, err := unchanged(path, group); err != nil {
+		return err
+	}
+	if _, err := unchanged(group.Paths[0], group); err != nil {
+		return fmt.Errorf("kept file %s: %w", group.Paths[0], err)
+	}
+	if dryRun {
+		return nil
+	}
+
+	// The copy is about to be thrown away and every link will show the kept
+	// file, so be sure of the content of both
+	checksum, err := HashFile(path)
+	if err != nil {
+		return err
+	}
+	if checksum != group.Checksum {
+		return errors.New("changed since it was scanned")
+	}
+	checksum, err = HashFile(group.Paths[0])
+	if err != nil {
+		return fmt.Errorf("kept file %s: %w", group.Paths[0], err)
+	}
+	if checksum != group.Checksum {
+		return fmt.Errorf("kept file %s changed since it was scanned", group.Paths[0])
+	}
+
+	partial := partialPath(path, newPartialToken())
+	if err := os.Link(group.Paths[0], partial); err != nil {
+		return fmt.Errorf("linking: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("renaming link into place: %w", err)
+	}
+	return nil
+}
+
+// quarantine moves path to newPath, copying it when newPath is on another
+// filesystem
+func quarantine(ctx context.Context, path, newPath string, group DuplicateGroup) error {
+	info, err := unchanged(path, group)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+		return fmt.Errorf("creating directory: %w", err)
+	}
+	if _, err := os.Lstat(newPath); !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s already exists", newPath)
+	}
+	_, err = moveFile(ctx, path, newPath, info, MoveOptions{Verify: true})
+	return err
+}
+
+// quarantineRoot is a root and the name its copies are quarantined under
+type quarantineRoot struct {
+	path, name string
+}
+
+// quarantineNames names every root after its base name, with _1, _2, ...
+// added when roots share one
+func quarantineNames(roots []string) []quarantineRoot {
+	var named []quarantineRoot
+	taken := make(map[string]bool)
+	for _, root := range roots {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(absRoot)
+		for counter := 1; taken[name]; counter++ {
+			name = fmt.Sprintf("%s_%d", filepath.Base(absRoot), counter)
+		}
+		taken[name] = true
+		named = append(named, quarantineRoot{absRoot, name})
+	}
+	return named
+}
+
+// quarantinePath returns where path goes under dir: below the name of the
+// first root it is in, at the same relative path
+func quarantinePath(path, dir string, roots []quarantineRoot) (string, error) {
+	for _, root := range roots {
+		if within(path, root.path) {
+			rel, err := filepath.Rel(root.path, path)
+			if err != nil {
+				return "", err
+			}
+			return filepath.Join(dir, root.name, rel), nil
+		}
+	}
+	return "", errors.New("not inside any of the roots")
+}
@@ -0,0 +1,116 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	big := strings.Repeat("x", partialHashSize+100)
+	writeTree(t, a, map[string]string{
+		"big":   big,
+		"small": "hello",
+		"other": "world",
+		"empty": "",
+	})
+	writeTree(t, b, map[string]string{
+		"sub/big":   big,
+		"small":     "hello",
+		"same-size": "hellp",
+		"tail":      big[:len(big)-1] + "y", // only the full hash tells it apart
+		"empty":     "",
+	})
+	if err := os.Link(filepath.Join(a, "other"), filepath.Join(b, "other-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	groups, errs := FindDuplicates(context.Background(), []string{a, b}, Filter{})
+	if len(errs) > 0 {
+		t.Fatalf("errors: %v", errs)
+	}
+	want := []DuplicateGroup{
+		{Size: int64(len(big)), Paths: []string{filepath.Join(a, "big"), filepath.Join(b, "sub", "big")}},
+		{Size: 5, Paths: []string{filepath.Join(a, "small"), filepath.Join(b, "small")}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("found %d groups, want %d: %v", len(groups), len(want), groups)
+	}
+	for i, group := range groups {
+		if group.Size != want[i].Size || strings.Join(group.Paths, " ") != strings.Join(want[i].Paths, " ") {
+			t.Errorf("group %d = %d bytes %v, want %d bytes %v", i, group.Size, group.Paths, want[i].Size, want[i].Paths)
+		}
+	}
+}
+
+func TestResolveDuplicates(t *testing.T) {
+	for _, action := range []string{DuplicatesHardlink, DuplicatesQuarantine} {
+		t.Run(action, func(t *testing.T) {
+			a, b, quarantine := t.TempDir(), t.TempDir(), t.TempDir()
+			writeTree(t, a, map[string]string{"kept": "same"})
+			writeTree(t, b, map[string]string{"dir/copy": "same"})
+			ctx := context.Background()
+
+			groups, errs := FindDuplicates(ctx, []string{a, b}, Filter{})
+			if len(errs) > 0 || len(groups) != 1 {
+				t.Fatalf("found %v, errors %v", groups, errs)
+			}
+			manifest := filepath.Join(t.TempDir(), "duplicates.jsonl")
+			opts := DuplicateOptions{Action: action, QuarantineDir: quarantine, Roots: []string{a, b}, ManifestPath: manifest, Log: io.Discard}
+			done, errs := ResolveDuplicates(ctx, groups, false, opts)
+			if len(errs) > 0 || len(done) != 1 {
+				t.Fatalf("did %v, errors %v", done, errs)
+			}
+
+			copyPath := filepath.Join(b, "dir", "copy")
+			switch action {
+			case DuplicatesHardlink:
+				kept, _ := os.Stat(filepath.Join(a, "kept"))
+				linked, err := os.Stat(copyPath)
+				if err != nil || !os.SameFile(kept, linked) {
+					t.Errorf("%s is not a link to the kept file: %v", copyPath, err)
+				}
+			case DuplicatesQuarantine:
+				if _, err := os.Stat(copyPath); !os.IsNotExist(err) {
+					t.Errorf("%s is still there", copyPath)
+				}
+				want := filepath.Join(quarantine, filepath.Base(b), "dir", "copy")
+				if done[0].NewPath != want {
+					t.Errorf("quarantined to %s, want %s", done[0].NewPath, want)
+				}
+				if content, err := os.ReadFile(want); err != nil || string(content) != "same" {
+					t.Errorf("quarantined copy holds %q: %v", content, err)
+				}
+			}
+			if data, err := os.ReadFile(manifest); err != nil || strings.Count(string(data), "\n") != 1 {
+				t.Errorf("manifest holds %q: %v", data, err)
+			}
+		})
+	}
+}
+
+func TestHardlinkChecksKeptFile(t *testing.T) {
+	a, b := t.TempDir(), t.TempDir()
+	writeTree(t, a, map[string]string{"kept": "same"})
+	writeTree(t, b, map[string]string{"copy": "same"})
+	ctx := context.Background()
+
+	groups, errs := FindDuplicates(ctx, []string{a, b}, Filter{})
+	if len(errs) > 0 || len(groups) != 1 {
+		t.Fatalf("found %v, errors %v", groups, errs)
+	}
+	// Same size, other content
+	writeTree(t, a, map[string]string{"kept": "diff"})
+
+	done, errs := ResolveDuplicates(ctx, groups, false, DuplicateOptions{Action: DuplicatesHardlink, Log: io.Discard})
+	if len(done) != 0 || len(errs) != 1 {
+		t.Fatalf("did %v, errors %v", done, errs)
+	}
+	if content, err := os.ReadFile(filepath.Join(b, "copy")); err != nil || string(content) != "same" {
+		t.Errorf("copy holds %q: %v", content, err)
+	}
+}
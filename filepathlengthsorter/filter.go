@@ -0,0 +1,516 @@
+package filepathlengthsorter
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What Filter.WalkDir does with symbolic links
+const (
+	// SymlinksMove treats a link as a file of its own, so the link rather
+	// than what it points to is moved
+	SymlinksMove = "move"
+	// SymlinksSkip leaves links alone
+	SymlinksSkip = "skip"
+	// SymlinksFollow walks into linked directories and judges linked files
+	// by their target. Targets inside the tree, or inside a directory that
+	// is already being walked, are not walked twice.
+	SymlinksFollow = "follow"
+)
+
+// Pattern is one include, exclude or ignore file rule. Globs follow
+// .gitignore rules: without a slash they match a name at any depth, with
+// one they match the path relative to the source directory. Regular
+// expressions, written as "re:<expression>", are searched for in the
+// relative path with forward slashes.
+type Pattern struct {
+	source  string
+	re      *regexp.Regexp
+	negate  bool // "!" in an ignore file brings back what earlier rules excluded
+	dirOnly bool // a trailing "/" only matches directories
+}
+
+// ParsePattern compiles a glob or "re:" regular expression
+func ParsePattern(s string) (Pattern, error) {
+	p := Pattern{source: s}
+	if expr, ok := strings.CutPrefix(s, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return p, fmt.Errorf("pattern %q: %w", s, err)
+		}
+		p.re = re
+		return p, nil
+	}
+
+	glob := s
+	if rest, ok := strings.CutPrefix(glob, "!"); ok {
+		p.negate = true
+		glob = rest
+	}
+	if rest, ok := strings.CutSuffix(glob, "/"); ok {
+		p.dirOnly = true
+		glob = rest
+	}
+	if glob == "" {
+		return p, fmt.Errorf("empty pattern %q", s)
+	}
+
+	anchored := strings.Contains(glob, "/")
+	glob = strings.TrimPrefix(glob, "/")
+	expr := globToRegexp(glob)
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "(^|/)" + expr + "$"
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return p, fmt.Errorf("pattern %q: %w", s, err)
+	}
+	p.re = re
+	return p, nil
+}
+
+// globToRegexp translates a glob with *, ?, [...] and ** into a regular
+// expression matching slash separated paths
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// matches reports whether the pattern matches rel, a slash separated path
+// relative to the source directory
+func (p Pattern) matches(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	return p.re.MatchString(rel)
+}
+
+// LoadIgnoreFile reads the rules of a .gitignore-style file. Blank lines and
+// lines starting with # are skipped.
+func LoadIgnoreFile(path string) ([]Pattern, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var patterns []Pattern
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t\r")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		p, err := ParsePattern(text)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// Filter decides which entries of the source tree are looked at. The zero
+// value lets everything through and moves symlinks like files.
+type Filter struct {
+	// Include, when not empty, limits the files considered to those
+	// matching at least one pattern. Directories are always walked.
+	Include []Pattern
+	// Exclude skips matching files and directories, including everything
+	// below an excluded directory. The last matching rule decides, so a
+	// negated rule can bring back something an earlier one excluded.
+	Exclude []Pattern
+	// MinSize and MaxSize bound the size of files considered. Zero means
+	// no bound.
+	MinSize int64
+	MaxSize int64
+	// OlderThan and NewerThan bound the modification time of files
+	// considered. The zero time means no bound.
+	OlderThan time.Time
+	NewerThan time.Time
+	// Symlinks is one of the Symlinks constants. Empty means SymlinksMove.
+	Symlinks string
+}
+
+// excluded reports whether the Exclude rules leave out rel
+func (f Filter) excluded(rel string, isDir bool) bool {
+	excluded := false
+	for _, p := range f.Exclude {
+		if p.matches(rel, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// wantsFile reports whether a file at rel passes the include, size and time
+// filters. info is only fetched when one of them needs it.
+func (f Filter) wantsFile(rel string, d fs.DirEntry) (bool, error) {
+	if len(f.Include) > 0 {
+		included := false
+		for _, p := range f.Include {
+			if p.matches(rel, false) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false, nil
+		}
+	}
+
+	if f.MinSize == 0 && f.MaxSize == 0 && f.OlderThan.IsZero() && f.NewerThan.IsZero() {
+		return true, nil
+	}
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	return (f.MinSize == 0 || info.Size() >= f.MinSize) &&
+		(f.MaxSize == 0 || info.Size() <= f.MaxSize) &&
+		(f.OlderThan.IsZero() || info.ModTime().Before(f.OlderThan)) &&
+		(f.NewerThan.IsZero() || info.ModTime().After(f.NewerThan)), nil
+}
+
+// WalkDir walks root like filepath.WalkDir, but only calls fn for the
+// entries the filter lets through. Followed links to directories are
+// reported as directories and walked under the link's path.
+func (f Filter) WalkDir(root string, fn fs.WalkDirFunc) error {
+	w := &filterWalk{filter: f, root: root, fn: fn}
+	if f.Symlinks == SymlinksFollow {
+		realRoot, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return fn(root, nil, err)
+		}
+		w.walked = []string{realRoot}
+	}
+	return w.walk(root, false)
+}
+
+// Wants reports whether WalkDir would let through the file at rel, a
+// slash separated path relative to the tree, described by info. It is for
+// lists of files that are not walked, such as snapshots.
+func (f Filter) Wants(rel string, info fs.FileInfo) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if f.excluded(strings.Join(parts[:i], "/"), true) {
+			return false
+		}
+	}
+	if f.excluded(rel, false) {
+		return false
+	}
+	wanted, _ := f.wantsFile(rel, fs.FileInfoToDirEntry(info))
+	return wanted
+}
+
+// filterWalk is the state of one Filter.WalkDir
+type filterWalk struct {
+	filter  Filter
+	root    string
+	fn      fs.WalkDirFunc
+	walked  []string // real paths of the trees being walked
+	stopped bool     // fn returned filepath.SkipAll
+}
+
+// walk walks dir. A linked directory is walked through dir + separator so
+// WalkDir resolves the link, and is itself not reported again.
+func (w *filterWalk) walk(dir string, linked bool) error {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if w.stopped {
+			return filepath.SkipAll
+		}
+		if linked && path == dir {
+			return nil
+		}
+		if err != nil || path == w.root {
+			return w.call(path, d, err)
+		}
+
+		rel, err := filepath.Rel(w.root, path)
+		if err != nil {
+			return w.call(path, d, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			switch w.filter.Symlinks {
+			case SymlinksSkip:
+				return nil
+			case SymlinksFollow:
+				info, err := os.Stat(path)
+				if err != nil {
+					return w.call(path, d, err)
+				}
+				d = fs.FileInfoToDirEntry(info)
+				if info.IsDir() {
+					return w.followDir(path, rel, d)
+				}
+			}
+		}
+
+		if w.filter.excluded(rel, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			wanted, err := w.filter.wantsFile(rel, d)
+			if err != nil {
+				return w.call(path, d, err)
+			}
+			if !wanted {
+				return nil
+			}
+		}
+		return w.call(path, d, nil)
+	})
+	if w.stopped {
+		return nil
+	}
+	return err
+}
+
+// followDir reports and walks the directory a followed link points to,
+// unless it overlaps a tree that is already being walked
+func (w *filterWalk) followDir(path, rel string, d fs.DirEntry) error {
+	if w.filter.excluded(rel, true) {
+		return nil
+	}
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return w.call(path, d, err)
+	}
+	for _, walked := range w.walked {
+		if within(target, walked) || within(walked, target) {
+			return nil
+		}
+	}
+
+	if err := w.call(path, d, nil); err != nil {
+		if errors.Is(err, filepath.SkipDir) {
+			return nil
+		}
+		return err
+	}
+	w.walked = append(w.walked, target)
+	return w.walk(path+string(filepath.Separator), true)
+}
+
+// call passes an entry on to fn, remembering when the walk is to stop
+func (w *filterWalk) call(path string, d fs.DirEntry, err error) error {
+	err = w.fn(path, d, err)
+	if errors.Is(err, filepath.SkipAll) {
+		w.stopped = true
+	}
+	return err
+}
+
+// within reports whether path is dir or inside it
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// String describes the filter for the run summary
+func (f Filter) String() string {
+	var parts []string
+	describe := func(name string, patterns []Pattern) {
+		if len(patterns) == 0 {
+			return
+		}
+		sources := make([]string, len(patterns))
+		for i, p := range patterns {
+			sources[i] = p.source
+		}
+		parts = append(parts, name+" "+strings.Join(sources, ", "))
+	}
+	describe("include", f.Include)
+	describe("exclude", f.Exclude)
+	if f.MinSize > 0 {
+		parts = append(parts, "at least "+FormatFileSize(f.MinSize))
+	}
+	if f.MaxSize > 0 {
+		parts = append(parts, "at most "+FormatFileSize(f.MaxSize))
+	}
+	if !f.OlderThan.IsZero() {
+		parts = append(parts, "modified before "+f.OlderThan.Format(time.RFC3339))
+	}
+	if !f.NewerThan.IsZero() {
+		parts = append(parts, "modified after "+f.NewerThan.Format(time.RFC3339))
+	}
+	if f.Symlinks != "" && f.Symlinks != SymlinksMove {
+		parts = append(parts, "symlinks "+f.Symlinks)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// ParseSize reads a size such as 512, 10K, 1.5MiB or 2GB. All units are
+// powers of 1024.
+func ParseSize(s string) (int64, error) {
+	number := strings.TrimRight(s, "KMGTPiBkmgtpib ")
+	unit := strings.ToUpper(strings.TrimSpace(s[len(number):]))
+	unit = strings.TrimSuffix(strings.TrimSuffix(unit, "B"), "I")
+
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	exp := strings.Index("KMGTP", unit) + 1
+	if (unit != "" && exp == 0) || len(unit) > 1 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	for range exp {
+		value *= 1024
+	}
+	return int64(value), nil
+}
+
+// ParseTime reads a point in time given as a date, an RFC 3339 timestamp or
+// an age such as 90d or 36h, which is taken back from now
+func ParseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if age, err := time.ParseDuration(s); err == nil && age >= 0 {
+		return now.Add(-age), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: expected a date, an RFC 3339 time or an age like 90d", s)
+}
+
+// patternList collects the values of a repeatable pattern flag
+type patternList []Pattern
+
+func (l *patternList) String() string {
+	return fmt.Sprint(len(*l), " patterns")
+}
+
+func (l *patternList) Set(value string) error {
+	p, err := ParsePattern(value)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, p)
+	return nil
+}
+
+// stringList collects the values of a repeatable string flag
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// FilterFlags registers the flags that build a Filter on fs and returns a
+// function building the filter once fs has been parsed
+func FilterFlags(fs *flag.FlagSet) func() (Filter, error) {
+	var include, exclude patternList
+	var ignoreFiles stringList
+	fs.Var(&include, "include", "Only consider files matching this glob or re:<regexp> (repeatable)")
+	fs.Var(&exclude, "exclude", "Skip files and directories matching this glob or re:<regexp> (repeatable)")
+	fs.Var(&ignoreFiles, "ignore-file", "Skip what this .gitignore-style file lists, relative to -src (repeatable)")
+	minSize := fs.String("min-size", "", "Only consider files of at least this size, e.g. 10M")
+	maxSize := fs.String("max-size", "", "Only consider files of at most this size, e.g. 2G")
+	olderThan := fs.String("older-than", "", "Only consider files modified before this date, time or age (e.g. 2024-01-31 or 90d)")
+	newerThan := fs.String("newer-than", "", "Only consider files modified after this date, time or age")
+	symlinks := fs.String("symlinks", SymlinksMove, "What to do with symbolic links: move, skip or follow")
+
+	return func() (Filter, error) {
+		filter := Filter{Include: include, Symlinks: *symlinks}
+		switch *symlinks {
+		case SymlinksMove, SymlinksSkip, SymlinksFollow:
+		default:
+			return filter, fmt.Errorf("unknown symlinks mode %q", *symlinks)
+		}
+
+		// Ignore files come first so -exclude can add to them
+		for _, path := range ignoreFiles {
+			patterns, err := LoadIgnoreFile(path)
+			if err != nil {
+				return filter, fmt.Errorf("reading ignore file: %w", err)
+			}
+			filter.Exclude = append(filter.Exclude, patterns...)
+		}
+		filter.Exclude = append(filter.Exclude, exclude...)
+
+		var err error
+		if *minSize != "" {
+			if filter.MinSize, err = ParseSize(*minSize); err != nil {
+				return filter, err
+			}
+		}
+		if *maxSize != "" {
+			if filter.MaxSize, err = ParseSize(*maxSize); err != nil {
+				return filter, err
+			}
+		}
+		now := time.Now()
+		if *olderThan != "" {
+			if filter.OlderThan, err = ParseTime(*olderThan, now); err != nil {
+				return filter, err
+			}
+		}
+		if *newerThan != "" {
+			if filter.NewerThan, err = ParseTime(*newerThan, now); err != nil {
+				return filter, err
+			}
+		}
+		return filter, nil
+	}
+}
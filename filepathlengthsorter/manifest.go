@@ -0,0 +1,163 @@
+package filepathlengthsorter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// manifest is the undo journal of a real run. Each line is one FileMove
+// encoded as JSON, written as soon as the move has happened so that the
+// journal stays usable if the run is interrupted.
+type manifest struct {
+	file *os.File
+	enc  *json.Encoder
+}
+
+// DefaultManifestPath names a manifest after the current time
+func DefaultManifestPath() string {
+	return fmt.Sprintf("moves-%s.jsonl", time.Now().Format("20060102-150405"))
+}
+
+// openManifest opens the manifest at path for appending, creating it if needed
+func openManifest(path string) (*manifest, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &manifest{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record appends move to the manifest and flushes it to disk
+func (m *manifest) Record(move FileMove) error {
+	if err := m.enc.Encode(move); err != nil {
+		return err
+	}
+	return m.file.Sync()
+}
+
+func (m *manifest) Close() error {
+	return m.file.Close()
+}
+
+// readManifest returns every move recorded in the manifest at path
+func readManifest(path string) ([]FileMove, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var moves []FileMove
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var move FileMove
+		if err := json.Unmarshal(scanner.Bytes(), &move); err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		moves = append(moves, move)
+	}
+	return moves, scanner.Err()
+}
+
+// UndoMoves moves every file recorded in the manifest back to its original
+// path, newest move first. Files that cannot be restored are reported as
+// errors and left where they are. The returned moves describe the reverse
+// operations, i.e. OriginalPath is where the file was found. Cancelling ctx
+// stops before the next file. Progress is written to opts.Log.
+func UndoMoves(ctx context.Context, manifestPath string, dryRun bool, opts MoveOptions) ([]FileMove, []FileMoveError) {
+	var restored []FileMove
+	var errorFiles []FileMoveError
+	log := opts.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	moves, err := readManifest(manifestPath)
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: manifestPath, Error: err})
+		fmt.Fprintf(log, "Error reading manifest: %v\n", err)
+		return restored, errorFiles
+	}
+
+	fmt.Fprintf(log, "\nMode: %s\n", map[bool]string{true: "DRY RUN (no files will be moved)", false: "ACTUAL RUN"}[dryRun])
+	fmt.Fprintf(log, "Undoing %d moves from %s\n\n", len(moves), manifestPath)
+
+	for i := len(moves) - 1; i >= 0; i-- {
+		if ctx.Err() != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: manifestPath, Error: fmt.Errorf("interrupted with %d moves not undone: %w", i+1, ctx.Err())})
+			break
+		}
+		move := moves[i]
+
+		info, err := os.Lstat(move.NewPath)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: fmt.Errorf("moved file is missing: %w", err)})
+			fmt.Fprintf(log, "Conflict: %s no longer exists\n", move.NewPath)
+			continue
+		}
+
+		if _, err := os.Lstat(move.OriginalPath); err == nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: fmt.Errorf("original path %s is occupied", move.OriginalPath)})
+			fmt.Fprintf(log, "Conflict: %s already exists\n", move.OriginalPath)
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: err})
+			fmt.Fprintf(log, "Error checking %s: %v\n", move.OriginalPath, err)
+			continue
+		}
+
+		if opts.Verify && move.Checksum != "" {
+			checksum, err := HashFile(move.NewPath)
+			if err != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: err})
+				fmt.Fprintf(log, "Error hashing %s: %v\n", move.NewPath, err)
+				continue
+			}
+			if checksum != move.Checksum {
+				errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: errors.New("content changed since it was moved")})
+				fmt.Fprintf(log, "Conflict: %s changed since it was moved\n", move.NewPath)
+				continue
+			}
+		}
+
+		reverse := FileMove{
+			OriginalPath: move.NewPath,
+			NewPath:      move.OriginalPath,
+			FileSize:     info.Size(),
+			Checksum:     move.Checksum,
+		}
+		if dryRun {
+			restored = append(restored, reverse)
+			continue
+		}
+
+		// Recreate the directory tree the file came from
+		if err := os.MkdirAll(filepath.Dir(move.OriginalPath), 0755); err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: err})
+			fmt.Fprintf(log, "Error recreating directory for %s: %v\n", move.OriginalPath, err)
+			continue
+		}
+
+		if _, err := moveFile(ctx, move.NewPath, move.OriginalPath, info, opts); err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.NewPath, Error: err})
+			fmt.Fprintf(log, "Error restoring %s: %v\n", move.NewPath, err)
+			continue
+		}
+
+		reverse.MovedAt = time.Now().UTC()
+		restored = append(restored, reverse)
+		fmt.Fprintf(log, "Restored: %s\nTo: %s\n\n", move.NewPath, move.OriginalPath)
+	}
+
+	return restored, errorFiles
+}
@@ -0,0 +1,319 @@
+package filepathlengthsorter
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Units a path length can be counted in
+const (
+	UnitBytes = "bytes" // bytes of the UTF-8 encoding, what len() returns
+	UnitRunes = "runes" // Unicode code points
+	UnitUTF16 = "utf16" // UTF-16 code units, what Windows and SharePoint count
+)
+
+// PathMeasure describes how path lengths are counted and which path
+// components are not acceptable on the destination platform
+type PathMeasure struct {
+	Unit string
+	// SegmentLimit is the longest a single path component may be, counted
+	// in Unit. Zero means no limit.
+	SegmentLimit int
+	// ForbiddenChars lists characters no component may contain. Control
+	// characters are always forbidden when this is set.
+	ForbiddenChars string
+	// ReservedNames are component names (compared case-insensitively and
+	// without extensions) the platform refuses
+	ReservedNames []string
+	// ForbiddenSubstrings may not appear anywhere in a component
+	ForbiddenSubstrings []string
+	// NoTrailingDotOrSpace rejects components ending in "." or " "
+	NoTrailingDotOrSpace bool
+	// RootPrefix replaces the scanned root when measuring, e.g. the folder a
+	// OneDrive library syncs to. Empty means absolute paths are measured.
+	RootPrefix string
+}
+
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// measureProfiles are the selectable presets for -profile
+var measureProfiles = map[string]PathMeasure{
+	"bytes": {Unit: UnitBytes},
+	"runes": {Unit: UnitRunes},
+	"utf16": {Unit: UnitUTF16},
+	// NAME_MAX on common Linux and macOS filesystems
+	"posix": {Unit: UnitBytes, SegmentLimit: 255},
+	"windows": {
+		Unit:                 UnitUTF16,
+		SegmentLimit:         255,
+		ForbiddenChars:       `<>:"|?*`,
+		ReservedNames:        windowsReservedNames,
+		NoTrailingDotOrSpace: true,
+	},
+	"sharepoint": {
+		Unit:                 UnitUTF16,
+		SegmentLimit:         255,
+		ForbiddenChars:       `"*:<>?\|`,
+		ReservedNames:        append([]string{".LOCK", "DESKTOP.INI"}, windowsReservedNames...),
+		ForbiddenSubstrings:  []string{"_vti_"},
+		NoTrailingDotOrSpace: true,
+	},
+}
+
+// profileNames returns the names of the measurement profiles, sorted
+func profileNames() []string {
+	names := make([]string, 0, len(measureProfiles))
+	for name := range measureProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validUnit reports whether unit is one of the Unit constants
+func validUnit(unit string) bool {
+	return unit == UnitBytes || unit == UnitRunes || unit == UnitUTF16
+}
+
+// Count returns the length of s in the measure's unit
+func (m PathMeasure) Count(s string) int {
+	switch m.Unit {
+	case UnitRunes:
+		return utf8.RuneCountInString(s)
+	case UnitUTF16:
+		n := 0
+		for _, r := range s {
+			// Characters outside the Basic Multilingual Plane take a
+			// surrogate pair
+			if r >= 0x10000 {
+				n += 2
+			} else {
+				n++
+			}
+		}
+		return n
+	default:
+		return len(s)
+	}
+}
+
+// Length returns the measured length of path. When a RootPrefix is set,
+// path is measured as if root were replaced by that prefix.
+func (m PathMeasure) Length(path, root string) int {
+	if m.RootPrefix == "" || root == "" {
+		return m.Count(path)
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return m.Count(path)
+	}
+	return m.Count(strings.TrimRight(m.RootPrefix, `/\`) + string(filepath.Separator) + rel)
+}
+
+// HasComponentRules reports whether the measure checks anything beyond the
+// total length
+func (m PathMeasure) HasComponentRules() bool {
+	return m.SegmentLimit > 0 || m.ForbiddenChars != "" || len(m.ReservedNames) > 0 ||
+		len(m.ForbiddenSubstrings) > 0 || m.NoTrailingDotOrSpace
+}
+
+// ComponentProblems lists everything wrong with a single path component
+func (m PathMeasure) ComponentProblems(name string) []string {
+	var problems []string
+
+	if m.SegmentLimit > 0 {
+		if n := m.Count(name); n > m.SegmentLimit {
+			problems = append(problems, fmt.Sprintf("name is %d %s, limit is %d", n, m.Unit, m.SegmentLimit))
+		}
+	}
+
+	if m.ForbiddenChars != "" {
+		var found []string
+		for _, r := range name {
+			if r < 0x20 || strings.ContainsRune(m.ForbiddenChars, r) {
+				if q := fmt.Sprintf("%q", r); !slices.Contains(found, q) {
+					found = append(found, q)
+				}
+			}
+		}
+		if len(found) > 0 {
+			problems = append(problems, "contains forbidden characters "+strings.Join(found, " "))
+		}
+	}
+
+	// Windows ignores everything from the first dot, so "CON.tar.gz" is as
+	// reserved as "CON"
+	stem, _, _ := strings.Cut(strings.ToUpper(name), ".")
+	for _, reserved := range m.ReservedNames {
+		if stem == reserved || strings.ToUpper(name) == reserved {
+			problems = append(problems, "is a reserved name")
+			break
+		}
+	}
+
+	for _, substring := range m.ForbiddenSubstrings {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(substring)) {
+			problems = append(problems, fmt.Sprintf("contains %q", substring))
+		}
+	}
+
+	if m.NoTrailingDotOrSpace && (strings.HasSuffix(name, ".") || strings.HasSuffix(name, " ")) {
+		problems = append(problems, "ends in a dot or space")
+	}
+	return problems
+}
+
+// breaksComponentRules reports whether any component of path below root
+// has a problem ComponentProblems would list
+func (m PathMeasure) breaksComponentRules(path, root string) bool {
+	if !m.HasComponentRules() {
+		return false
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	for _, name := range strings.Split(rel, string(filepath.Separator)) {
+		if len(m.ComponentProblems(name)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// fixComponent changes name just enough for ComponentProblems to find
+// nothing wrong with it: forbidden characters become "_", forbidden
+// substrings and reserved names are broken up, trailing dots and spaces are
+// dropped and the name is cut to SegmentLimit bytes, which is within the
+// limit whatever the unit. Names without problems are returned unchanged.
+func (m PathMeasure) fixComponent(name string) string {
+	if m.ForbiddenChars != "" {
+		name = strings.Map(func(r rune) rune {
+			if r < 0x20 || strings.ContainsRune(m.ForbiddenChars, r) {
+				return '_'
+			}
+			return r
+		}, name)
+	}
+
+	for _, substring := range m.ForbiddenSubstrings {
+		pattern := regexp.MustCompile("(?i)" + regexp.QuoteMeta(substring))
+		for pattern.MatchString(name) {
+			name = pattern.ReplaceAllStringFunc(name, func(s string) string {
+				_, n := utf8.DecodeRuneInString(s)
+				return s[:n] + "-" + s[n:]
+			})
+		}
+	}
+
+	if m.NoTrailingDotOrSpace {
+		name = strings.TrimRight(name, ". ")
+	}
+
+	stem, ext, hasExt := strings.Cut(name, ".")
+	for _, reserved := range m.ReservedNames {
+		if strings.EqualFold(stem, reserved) || strings.EqualFold(name, reserved) {
+			name = stem + "_"
+			if hasExt {
+				name += "." + ext
+			}
+			break
+		}
+	}
+
+	if name == "" {
+		name = "_"
+	}
+	if m.SegmentLimit > 0 && m.Count(name) > m.SegmentLimit {
+		name = shortenSegment(name, m.SegmentLimit)
+	}
+	return name
+}
+
+// fixPath applies fixComponent to every component of the relative path rel
+func (m PathMeasure) fixPath(rel string) string {
+	if !m.HasComponentRules() {
+		return rel
+	}
+	segments := strings.Split(rel, string(filepath.Separator))
+	for i, segment := range segments {
+		segments[i] = m.fixComponent(segment)
+	}
+	return filepath.Join(segments...)
+}
+
+// PathIssue is a file or directory whose name the destination would reject
+type PathIssue struct {
+	Path    string
+	Problem string
+}
+
+// FindPathIssues walks sourceDir and reports every entry whose own name
+// breaks the component rules of measure, skipping what filter leaves out.
+// Each directory is reported once, not once per file below it.
+func FindPathIssues(sourceDir string, measure PathMeasure, filter Filter) ([]PathIssue, []FileMoveError) {
+	var issues []PathIssue
+	var errorFiles []FileMoveError
+
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, []FileMoveError{{Path: sourceDir, Error: err}}
+	}
+
+	err = filter.WalkDir(absSourceDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			return nil // Continue walking
+		}
+		if path == absSourceDir {
+			return nil
+		}
+
+		for _, problem := range measure.ComponentProblems(d.Name()) {
+			issues = append(issues, PathIssue{Path: path, Problem: problem})
+		}
+		return nil
+	})
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: absSourceDir, Error: err})
+	}
+	return issues, errorFiles
+}
+
+// MeasureFlags registers the flags that select a PathMeasure on fs and
+// returns a function building the measure once fs has been parsed
+func MeasureFlags(fs *flag.FlagSet) func() (PathMeasure, error) {
+	profile := fs.String("profile", "bytes", "How path lengths are measured: "+strings.Join(profileNames(), ", "))
+	unit := fs.String("unit", "", "Override the unit of the profile: bytes, runes or utf16")
+	segmentLimit := fs.Int("segment-limit", -1, "Override the per-component length limit of the profile (0 for none)")
+	rootPrefix := fs.String("root-prefix", "", "Measure paths as if the source directory were this path")
+
+	return func() (PathMeasure, error) {
+		measure, found := measureProfiles[*profile]
+		if !found {
+			return measure, fmt.Errorf("unknown profile %q", *profile)
+		}
+		if *unit != "" {
+			if !validUnit(*unit) {
+				return measure, fmt.Errorf("unknown unit %q", *unit)
+			}
+			measure.Unit = *unit
+		}
+		if *segmentLimit >= 0 {
+			measure.SegmentLimit = *segmentLimit
+		}
+		measure.RootPrefix = *rootPrefix
+		return measure, nil
+	}
+}
@@ -0,0 +1,62 @@
+package filepathlengthsorter
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFixComponent(t *testing.T) {
+	measure := measureProfiles["sharepoint"]
+	measure.SegmentLimit = 20
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.txt", "report.txt"},
+		{`a:b|c?.txt`, "a_b_c_.txt"},
+		{"CON", "CON_"},
+		{"con.tar.gz", "con_.tar.gz"},
+		{"desktop.ini", "desktop_.ini"},
+		{".lock", "_.lock"},
+		{"site_vti_pages", "site_-vti_pages"},
+		{"draft. ", "draft"},
+		{"...", "_"},
+		{"a-name-far-longer-than-twenty.txt", shortenSegment("a-name-far-longer-than-twenty.txt", 20)},
+	}
+	for _, tt := range tests {
+		got := measure.fixComponent(tt.name)
+		if got != tt.want {
+			t.Errorf("fixComponent(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if problems := measure.ComponentProblems(got); len(problems) > 0 {
+			t.Errorf("fixComponent(%q) = %q, which still %v", tt.name, got, problems)
+		}
+	}
+}
+
+func TestPlanShorteningRenamesRejectedNames(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"bad:dir/CON.txt": "",
+		"fine/notes.txt":  "",
+	})
+
+	plan, errs := PlanShortening(root, 4096, ShortenOptions{Measure: measureProfiles["windows"]})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := make(map[string]string)
+	for _, rename := range plan {
+		rel, _ := filepath.Rel(root, rename.Path)
+		got[filepath.ToSlash(rel)] = rename.NewName
+	}
+	want := map[string]string{"bad:dir": "bad_dir", "bad:dir/CON.txt": "CON_.txt"}
+	if len(got) != len(want) {
+		t.Errorf("plan renames %v, want %v", got, want)
+	}
+	for path, name := range want {
+		if got[path] != name {
+			t.Errorf("%s is renamed to %q, want %q", path, got[path], name)
+		}
+	}
+}
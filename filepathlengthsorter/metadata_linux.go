@@ -0,0 +1,61 @@
+package filepathlengthsorter
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// fileAccessTime returns the last access time recorded in info
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Unix())
+	}
+	return info.ModTime()
+}
+
+// copyOwnership gives dst the owner and group recorded in info
+func copyOwnership(dst string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+}
+
+// copyXattrs copies every extended attribute of src onto dst. Filesystems
+// without xattr support are silently skipped.
+func copyXattrs(src, dst string) error {
+	size, err := syscall.Listxattr(src, nil)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil
+	}
+	if err != nil || size == 0 {
+		return err
+	}
+
+	names := make([]byte, size)
+	size, err = syscall.Listxattr(src, names)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range strings.Split(strings.TrimRight(string(names[:size]), "\x00"), "\x00") {
+		valueSize, err := syscall.Getxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = syscall.Getxattr(src, name, value)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", name, err)
+		}
+		if err := syscall.Setxattr(dst, name, value[:valueSize], 0); err != nil {
+			return fmt.Errorf("writing %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package filepathlengthsorter
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// fileAccessTime falls back to the modification time where the access time
+// is not exposed
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// copyOwnership is only implemented on Linux
+func copyOwnership(dst string, info os.FileInfo) error {
+	return errors.ErrUnsupported
+}
+
+// copyXattrs is only implemented on Linux
+func copyXattrs(src, dst string) error {
+	return errors.ErrUnsupported
+}
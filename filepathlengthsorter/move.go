@@ -0,0 +1,650 @@
+// Package filepathlengthsorter finds files whose paths are too long for
+// where they are going and moves or renames them until they fit.
+// MoveLongPaths relocates them under another directory, PlanShortening
+// renames them in place and UndoMoves reverses either from the manifest
+// they write. The commands under cmd/ are built on it.
+package filepathlengthsorter
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxLength is the maximum path length the commands use when none
+// is given
+const DefaultMaxLength = 180
+
+// FileMove represents a file move operation
+type FileMove struct {
+	OriginalPath string    `json:"original_path"`
+	NewPath      string    `json:"new_path"`
+	FileSize     int64     `json:"size"`
+	Checksum     string    `json:"sha256,omitempty"` // only set when verifying
+	MovedAt      time.Time `json:"moved_at"`         // zero in dry runs
+}
+
+// FileMoveError represents a file move error
+type FileMoveError struct {
+	Path  string
+	Error error
+}
+
+// MoveOptions holds the optional behaviour of MoveLongPaths
+type MoveOptions struct {
+	// PreserveOwner keeps the owner and group of files that have to be
+	// copied across filesystems. This usually requires root.
+	PreserveOwner bool
+	// PreserveXattrs keeps extended attributes of files that have to be
+	// copied across filesystems
+	PreserveXattrs bool
+	// Verify checksums every copy against the original before the original
+	// is deleted, and records the checksum of every moved file
+	Verify bool
+	// ManifestPath is where a real run journals every move so that it can be
+	// undone later. No manifest is written when it is empty.
+	ManifestPath string
+	// Strategy decides where under the target directory files go, one of
+	// the Strategy constants. Empty means StrategyFlatten.
+	Strategy string
+	// SegmentLength is the longest directory name StrategyMirror keeps
+	SegmentLength int
+	// Measure decides how path lengths are counted. The zero value counts
+	// bytes of the absolute path.
+	Measure PathMeasure
+	// Workers is how many files are moved at the same time. Anything below
+	// one moves them one by one.
+	Workers int
+	// Progress is where a progress line is redrawn while running, usually a
+	// terminal. Nothing is drawn when it is nil.
+	Progress io.Writer
+	// Filter limits which files are looked at, in dry runs and real ones
+	Filter Filter
+	// StatePath is where a real run saves its plan so that it can be
+	// resumed. No state is saved when it is empty.
+	StatePath string
+	// CaseInsensitive makes target names that differ only in case clash
+	// even when the target filesystem tells them apart. Case-insensitive
+	// filesystems are detected on their own.
+	CaseInsensitive bool
+	// Log is where MoveLongPaths describes what it is doing. Nil means
+	// stdout.
+	Log io.Writer
+
+	// onCopy is told how many bytes each write of a copy added
+	onCopy func(int)
+	// partialToken names the temporary copies of this run, see partialPath
+	partialToken string
+}
+
+// moveResult is what a worker reports for one planned move
+type moveResult struct {
+	index int
+	move  FileMove
+	err   error
+}
+
+// MoveLongPaths finds and moves files with paths longer than maxLength or
+// with a name along the path that the component rules of opts.Measure
+// reject; such names are fixed in the new path. The source is scanned first
+// and every target chosen in walk order by planMoves, which is where a dry
+// run stops. A real run then shares the moves out among opts.Workers
+// goroutines and reports them in walk order, whichever finishes first.
+// Cancelling ctx stops the run once the moves in flight are finished or
+// rolled back.
+func MoveLongPaths(ctx context.Context, sourceDir, targetDir string, maxLength int, dryRun bool, opts MoveOptions) ([]FileMove, []FileMoveError) {
+	var movedFiles []FileMove
+	var errorFiles []FileMoveError
+	log := opts.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	// Convert to absolute paths
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: sourceDir, Error: err})
+		fmt.Fprintf(log, "Error resolving source path: %v\n", err)
+		return movedFiles, errorFiles
+	}
+
+	absTargetDir, err := filepath.Abs(targetDir)
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: targetDir, Error: err})
+		fmt.Fprintf(log, "Error resolving target path: %v\n", err)
+		return movedFiles, errorFiles
+	}
+
+	workers := max(opts.Workers, 1)
+	fmt.Fprintf(log, "\nMode: %s\n", map[bool]string{true: "DRY RUN (no files will be moved)", false: "ACTUAL RUN"}[dryRun])
+	fmt.Fprintf(log, "Processing source directory: %s\n", absSourceDir)
+	fmt.Fprintf(log, "Target directory: %s\n", absTargetDir)
+	fmt.Fprintf(log, "Maximum path length: %d %s\n", maxLength, cmp.Or(opts.Measure.Unit, UnitBytes))
+	if opts.Measure.RootPrefix != "" {
+		fmt.Fprintf(log, "Measured as if under: %s\n", opts.Measure.RootPrefix)
+	}
+	fmt.Fprintf(log, "Relocation strategy: %s\n", cmp.Or(opts.Strategy, StrategyFlatten))
+	if filters := opts.Filter.String(); filters != "" {
+		fmt.Fprintf(log, "Filters: %s\n", filters)
+	}
+	if !dryRun {
+		fmt.Fprintf(log, "Workers: %d\n", workers)
+	}
+	fmt.Fprintln(log)
+
+	if !dryRun {
+		// Create target directory if it doesn't exist
+		err = os.MkdirAll(absTargetDir, 0755)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: absTargetDir, Error: err})
+			fmt.Fprintf(log, "Error creating target directory: %v\n", err)
+			return movedFiles, errorFiles
+		}
+	}
+
+	prog := newProgress(opts.Progress)
+	planned, planErrors := planMoves(ctx, absSourceDir, absTargetDir, maxLength, opts, prog, log)
+	errorFiles = append(errorFiles, planErrors...)
+	if ctx.Err() != nil {
+		prog.Stop()
+		errorFiles = append(errorFiles, FileMoveError{Path: absSourceDir, Error: fmt.Errorf("interrupted while scanning: %w", ctx.Err())})
+		return movedFiles, errorFiles
+	}
+
+	if dryRun {
+		prog.Stop()
+		for _, p := range planned {
+			movedFiles = append(movedFiles, p.FileMove)
+		}
+		return movedFiles, errorFiles
+	}
+
+	// Save the plan so that an interrupted run can be resumed with it
+	opts.partialToken = newPartialToken()
+	if opts.StatePath != "" {
+		state := RunState{
+			Source:       absSourceDir,
+			Target:       absTargetDir,
+			MaxLength:    maxLength,
+			Strategy:     opts.Strategy,
+			Manifest:     opts.ManifestPath,
+			PartialToken: opts.partialToken,
+			CreatedAt:    time.Now().UTC(),
+		}
+		for _, p := range planned {
+			state.Moves = append(state.Moves, p.FileMove)
+		}
+		if err := writeState(opts.StatePath, state); err != nil {
+			prog.Stop()
+			errorFiles = append(errorFiles, FileMoveError{Path: opts.StatePath, Error: err})
+			fmt.Fprintf(log, "Error saving state: %v\n", err)
+			return movedFiles, errorFiles
+		}
+	}
+
+	movedFiles, applyErrors := applyMoves(ctx, absTargetDir, planned, opts, prog, log)
+	errorFiles = append(errorFiles, applyErrors...)
+	finishState(opts.StatePath, len(planned)-len(movedFiles), log)
+	return movedFiles, errorFiles
+}
+
+// applyMoves carries out planned moves with opts.Workers goroutines,
+// journalling each one as soon as it is done. Moves are reported in the
+// order of planned whichever finishes first.
+func applyMoves(ctx context.Context, absTargetDir string, planned []plannedMove, opts MoveOptions, prog *progress, log io.Writer) ([]FileMove, []FileMoveError) {
+	var movedFiles []FileMove
+	var errorFiles []FileMoveError
+	defer prog.Stop()
+
+	var journal, index *manifest
+	var err error
+	if opts.ManifestPath != "" {
+		journal, err = openManifest(opts.ManifestPath)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: opts.ManifestPath, Error: err})
+			fmt.Fprintf(log, "Error opening manifest: %v\n", err)
+			return movedFiles, errorFiles
+		}
+		defer journal.Close()
+	}
+
+	if opts.Strategy == StrategyBucket {
+		index, err = openManifest(filepath.Join(absTargetDir, bucketIndexName))
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: absTargetDir, Error: err})
+			fmt.Fprintf(log, "Error opening bucket index: %v\n", err)
+			return movedFiles, errorFiles
+		}
+		defer index.Close()
+	}
+
+	// Hand the moves out to the workers, stopping early on cancellation
+	prog.startMoving()
+	jobs := make(chan int)
+	results := make(chan moveResult)
+	go func() {
+		defer close(jobs)
+		for i := range planned {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range max(opts.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if ctx.Err() != nil {
+					continue // left unstarted, counted below
+				}
+				move, err := movePlanned(ctx, planned[i], opts, prog)
+				results <- moveResult{index: i, move: move, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// Journal every move as soon as it is done, but hold back the report of
+	// each one until everything before it in walk order has been reported
+	finished := make([]*moveResult, len(planned))
+	next := 0
+	report := func(result *moveResult) {
+		path := planned[result.index].OriginalPath
+		if result.err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: result.err})
+			fmt.Fprintf(log, "Error moving %s: %v\n", path, result.err)
+			return
+		}
+		movedFiles = append(movedFiles, result.move)
+		fmt.Fprintf(log, "Moved: %s\nTo: %s\n\n", path, result.move.NewPath)
+	}
+
+	for result := range results {
+		if result.err == nil {
+			if journal != nil {
+				if err := journal.Record(result.move); err != nil {
+					result.err = fmt.Errorf("moved to %s but not recorded in manifest: %w", result.move.NewPath, err)
+				}
+			}
+			if index != nil {
+				if err := index.Record(result.move); err != nil {
+					errorFiles = append(errorFiles, FileMoveError{Path: result.move.OriginalPath, Error: fmt.Errorf("recording move in bucket index: %w", err)})
+				}
+			}
+		}
+
+		finished[result.index] = &result
+		for next < len(finished) && finished[next] != nil {
+			report(finished[next])
+			next++
+		}
+	}
+
+	// After a cancellation some moves never started; report the rest
+	skipped := 0
+	for ; next < len(finished); next++ {
+		if finished[next] != nil {
+			report(finished[next])
+		} else {
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		errorFiles = append(errorFiles, FileMoveError{Path: absTargetDir, Error: fmt.Errorf("interrupted with %d files not moved: %w", skipped, ctx.Err())})
+	}
+
+	return movedFiles, errorFiles
+}
+
+// movePlanned performs a single planned move, or finishes one an earlier
+// run was interrupted in
+func movePlanned(ctx context.Context, p plannedMove, opts MoveOptions, prog *progress) (FileMove, error) {
+	var checksum string
+	var err error
+	switch p.resume {
+	case resumeRecord:
+		checksum, err = checksumIfVerifying(p.NewPath, opts)
+	case resumeRemoveSource:
+		checksum, err = finishCopy(p, opts)
+	default:
+		checksum, err = startMove(ctx, p, opts, prog)
+	}
+	if err != nil {
+		return FileMove{}, err
+	}
+	prog.moved.Add(1)
+	prog.movedBytes.Add(p.FileSize)
+
+	move := p.FileMove
+	move.Checksum = checksum
+	move.MovedAt = time.Now().UTC()
+	return move, nil
+}
+
+// startMove moves a file nothing has been done with yet
+func startMove(ctx context.Context, p plannedMove, opts MoveOptions, prog *progress) (string, error) {
+	if err := os.MkdirAll(filepath.Dir(p.NewPath), 0755); err != nil {
+		return "", fmt.Errorf("creating directory: %w", err)
+	}
+	// Rename replaces existing files, so make sure nothing took the name
+	// since the scan
+	if _, err := os.Lstat(p.NewPath); !os.IsNotExist(err) {
+		return "", fmt.Errorf("%s appeared after the scan", p.NewPath)
+	}
+
+	var copied int64
+	opts.onCopy = func(n int) {
+		copied += int64(n)
+		prog.copying.Add(int64(n))
+	}
+	checksum, err := moveFile(ctx, p.OriginalPath, p.NewPath, p.info, opts)
+	prog.copying.Add(-copied)
+	return checksum, err
+}
+
+// errNotSameDevice is ERROR_NOT_SAME_DEVICE, which Windows reports instead
+// of EXDEV when renaming across volumes
+const errNotSameDevice = syscall.Errno(17)
+
+// isCrossDevice reports whether err came from renaming across filesystems
+func isCrossDevice(err error) bool {
+	if errors.Is(err, syscall.EXDEV) {
+		return true
+	}
+	return runtime.GOOS == "windows" && errors.Is(err, errNotSameDevice)
+}
+
+// moveFile moves src to dst. A plain rename is tried first; only when src
+// and dst are on different filesystems is the file copied and the original
+// removed. When verifying, the SHA-256 of the file is returned.
+func moveFile(ctx context.Context, src, dst string, info os.FileInfo, opts MoveOptions) (string, error) {
+	err := os.Rename(src, dst)
+	if err != nil && !isCrossDevice(err) {
+		return "", err
+	}
+	if err != nil {
+		return copyAndRemove(ctx, src, dst, info, opts)
+	}
+
+	if !opts.Verify {
+		return "", nil
+	}
+	// A rename never touches the content, so the checksum is only recorded
+	checksum, err := HashFile(dst)
+	if err != nil {
+		return "", fmt.Errorf("computing checksum of %s: %w", dst, err)
+	}
+	return checksum, nil
+}
+
+// PartialSuffix marks a copy that is still being written. It only gets
+// its real name once it is complete, so an interrupted copy never leaves a
+// half-written file under the name of the original.
+const PartialSuffix = ".partial"
+
+// newPartialToken returns a random token for the temporary copies of one
+// run, so they can be told apart from files that were there before
+func newPartialToken() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// partialPath returns the hidden name next to dst that a run with token
+// writes the copy of dst to
+func partialPath(dst, token string) string {
+	dir, name := filepath.Split(dst)
+	return filepath.Join(dir, "."+name+"."+token+PartialSuffix)
+}
+
+// copyAndRemove copies src to dst with CopyVerified and only then deletes
+// src
+func copyAndRemove(ctx context.Context, src, dst string, info os.FileInfo, opts MoveOptions) (string, error) {
+	checksum, err := CopyVerified(ctx, src, dst, info, opts.copyOptions())
+	if err != nil {
+		return "", err
+	}
+
+	// Delete original file
+	err = os.Remove(src)
+	if err != nil {
+		return "", fmt.Errorf("removing original file: %w", err)
+	}
+	return checksum, nil
+}
+
+// CopyOptions holds the optional behaviour of CopyVerified. The zero value
+// carries over the mode and timestamps of the original.
+type CopyOptions struct {
+	// Verify reads every copy back and compares its SHA-256 with the
+	// original's
+	Verify bool
+	// PreserveOwner and PreserveXattrs are as in MoveOptions
+	PreserveOwner  bool
+	PreserveXattrs bool
+	// SkipMode and SkipTimes leave the copy with the mode and timestamps a
+	// new file gets
+	SkipMode  bool
+	SkipTimes bool
+	// OnCopy is told how many bytes each write of the copy added. It may
+	// block to hold the copy back.
+	OnCopy func(int)
+
+	// partialToken names the temporary copy, see partialPath. Empty means a
+	// new random one.
+	partialToken string
+}
+
+// copyOptions returns the part of opts that CopyVerified uses
+func (opts MoveOptions) copyOptions() CopyOptions {
+	return CopyOptions{
+		Verify:         opts.Verify,
+		PreserveOwner:  opts.PreserveOwner,
+		PreserveXattrs: opts.PreserveXattrs,
+		OnCopy:         opts.onCopy,
+		partialToken:   opts.partialToken,
+	}
+}
+
+// CopyVerified copies src, described by info, to dst. The copy is written
+// to a temporary file next to dst that never replaces an existing file,
+// checked against the size of src and, when verifying, its SHA-256, given
+// the metadata of src and only then renamed to dst, replacing whatever is
+// there. When verifying, the SHA-256 of the file is returned.
+func CopyVerified(ctx context.Context, src, dst string, info os.FileInfo, opts CopyOptions) (string, error) {
+	// Hash the source while it is being copied so it is only read once
+	var digest hash.Hash
+	if opts.Verify {
+		digest = sha256.New()
+	}
+
+	partial := partialPath(dst, cmp.Or(opts.partialToken, newPartialToken()))
+	err := CopyFile(ctx, src, partial, digest, opts.OnCopy)
+	if errors.Is(err, os.ErrExist) {
+		return "", fmt.Errorf("copying: %s is in the way", partial)
+	}
+	if err != nil {
+		// Attempt to clean up failed copy
+		os.Remove(partial)
+		return "", fmt.Errorf("copying: %w", err)
+	}
+
+	// Verify sizes match
+	partialInfo, err := os.Stat(partial)
+	if err != nil {
+		return "", fmt.Errorf("verifying destination file %s: %w", partial, err)
+	}
+	if info.Size() != partialInfo.Size() {
+		os.Remove(partial)
+		return "", fmt.Errorf("size mismatch after copy")
+	}
+
+	// Read the copy back from disk and compare content
+	var checksum string
+	if opts.Verify {
+		checksum = hex.EncodeToString(digest.Sum(nil))
+		dstChecksum, err := HashFile(partial)
+		if err != nil {
+			os.Remove(partial)
+			return "", fmt.Errorf("verifying destination file %s: %w", partial, err)
+		}
+		if dstChecksum != checksum {
+			os.Remove(partial)
+			return "", fmt.Errorf("checksum mismatch after copy: source %s, destination %s", checksum, dstChecksum)
+		}
+	}
+
+	err = preserveMetadata(src, partial, info, opts)
+	if err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+
+	if err := os.Rename(partial, dst); err != nil {
+		os.Remove(partial)
+		return "", fmt.Errorf("renaming copy into place: %w", err)
+	}
+	return checksum, nil
+}
+
+// HashFile returns the hex SHA-256 of the file at path
+func HashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	digest := sha256.New()
+	if _, err := io.Copy(digest, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// preserveMetadata copies the mode, timestamps and optionally the ownership
+// and extended attributes of src (described by info) onto dst, as opts asks
+func preserveMetadata(src, dst string, info os.FileInfo, opts CopyOptions) error {
+	// Ownership first, since chown clears the setuid and setgid bits
+	if opts.PreserveOwner {
+		if err := copyOwnership(dst, info); err != nil {
+			return fmt.Errorf("preserving ownership: %w", err)
+		}
+	}
+
+	if !opts.SkipMode {
+		mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		if err := os.Chmod(dst, mode); err != nil {
+			return fmt.Errorf("preserving mode: %w", err)
+		}
+	}
+
+	if opts.PreserveXattrs {
+		if err := copyXattrs(src, dst); err != nil {
+			return fmt.Errorf("preserving extended attributes: %w", err)
+		}
+	}
+
+	// Timestamps last so nothing above bumps them again
+	if !opts.SkipTimes {
+		if err := os.Chtimes(dst, fileAccessTime(info), info.ModTime()); err != nil {
+			return fmt.Errorf("preserving timestamps: %w", err)
+		}
+	}
+	return nil
+}
+
+// copyBufferSize is the chunk size of copies, large enough that checking
+// for cancellation between chunks costs nothing
+const copyBufferSize = 1 << 20
+
+// contextReader fails reads once ctx is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// writeCounter passes the size of every write to a callback
+type writeCounter func(int)
+
+func (c writeCounter) Write(p []byte) (int, error) {
+	c(len(p))
+	return len(p), nil
+}
+
+// CopyFile copies a file from src to dst, also feeding the content to digest
+// and reporting progress to onCopy when they are not nil. dst must not
+// exist yet, so nothing is ever overwritten; an error satisfying
+// errors.Is(err, fs.ErrExist) says it did. The copy stops with ctx's error
+// when ctx is cancelled.
+func CopyFile(ctx context.Context, src, dst string, digest hash.Hash, onCopy func(int)) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	// Create destination file
+	destFile, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	// Copy the contents
+	writers := []io.Writer{destFile}
+	if digest != nil {
+		writers = append(writers, digest)
+	}
+	if onCopy != nil {
+		writers = append(writers, writeCounter(onCopy))
+	}
+	_, err = io.CopyBuffer(io.MultiWriter(writers...), contextReader{ctx, sourceFile}, make([]byte, copyBufferSize))
+	if err != nil {
+		return err
+	}
+
+	// Sync to ensure write is complete
+	return destFile.Sync()
+}
+
+// FormatFileSize returns a human-readable file size
+func FormatFileSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
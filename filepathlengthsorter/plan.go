@@ -0,0 +1,170 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"unicode"
+)
+
+// plannedMove is a file found by the scan together with where it goes
+type plannedMove struct {
+	FileMove
+	info   os.FileInfo
+	resume int // how much of the move an interrupted run already did
+}
+
+// movePlanner chooses the target of every file MoveLongPaths moves. It
+// looks at what is already in each target directory as well as at the
+// targets given to earlier files of the run, so a dry run plans exactly
+// what a real run then does.
+type movePlanner struct {
+	foldCase bool                       // names differing only in case clash
+	taken    map[string]map[string]bool // directory -> names in use
+}
+
+func newMovePlanner(foldCase bool) *movePlanner {
+	return &movePlanner{foldCase: foldCase, taken: make(map[string]map[string]bool)}
+}
+
+// key is how a name is looked up in taken
+func (p *movePlanner) key(name string) string {
+	if p.foldCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// names returns the names in use in dir: what is on disk, read once, plus
+// everything claimed since. A directory that does not exist yet is empty.
+func (p *movePlanner) names(dir string) map[string]bool {
+	if names, ok := p.taken[dir]; ok {
+		return names
+	}
+
+	names := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names[p.key(entry.Name())] = true
+	}
+	p.taken[dir] = names
+	return names
+}
+
+// claim returns newPath, only adding numbers if there's an actual conflict,
+// and reserves the result for this run
+func (p *movePlanner) claim(newPath string) string {
+	dir, baseFile := filepath.Split(newPath)
+	dir = filepath.Clean(dir)
+	names := p.names(dir)
+
+	if names[p.key(baseFile)] {
+		// If there's a conflict, then start adding numbers
+		ext := filepath.Ext(baseFile)
+		nameWithoutExt := strings.TrimSuffix(baseFile, ext)
+		for counter := 1; ; counter++ {
+			candidate := fmt.Sprintf("%s_%d%s", nameWithoutExt, counter, ext)
+			if !names[p.key(candidate)] {
+				baseFile = candidate
+				break
+			}
+		}
+	}
+
+	names[p.key(baseFile)] = true
+	return filepath.Join(dir, baseFile)
+}
+
+// caseInsensitiveFS reports whether the filesystem holding dir, or its
+// nearest existing parent, ignores case. It looks the directory up again
+// with the case of its name swapped; when no name on the way up has
+// letters it goes by the platform default.
+func caseInsensitiveFS(dir string) bool {
+	for {
+		if info, err := os.Stat(dir); err == nil {
+			base := filepath.Base(dir)
+			if swapped := swapCase(base); swapped != base {
+				other, err := os.Stat(filepath.Join(filepath.Dir(dir), swapped))
+				return err == nil && os.SameFile(info, other)
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+		}
+		dir = parent
+	}
+}
+
+// swapCase turns upper case letters into lower case ones and vice versa
+func swapCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsUpper(r) {
+			return unicode.ToLower(r)
+		}
+		return unicode.ToUpper(r)
+	}, s)
+}
+
+// planMoves walks absSourceDir and decides where every file with a long
+// path goes. The plan does not depend on whether it is then carried out.
+func planMoves(ctx context.Context, absSourceDir, absTargetDir string, maxLength int, opts MoveOptions, prog *progress, log io.Writer) ([]plannedMove, []FileMoveError) {
+	var planned []plannedMove
+	var errorFiles []FileMoveError
+	planner := newMovePlanner(opts.CaseInsensitive || caseInsensitiveFS(absTargetDir))
+
+	// Walk through all files in source directory
+	err := opts.Filter.WalkDir(absSourceDir, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			fmt.Fprintf(log, "Error accessing path %s: %v\n", path, err)
+			return nil // Continue walking
+		}
+
+		// Skip directories
+		if d.IsDir() {
+			return nil
+		}
+		prog.scanned.Add(1)
+
+		// Check path length and the names along the path
+		if opts.Measure.Length(path, absSourceDir) <= maxLength && !opts.Measure.breaksComponentRules(path, absSourceDir) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			fmt.Fprintf(log, "Error accessing path %s: %v\n", path, err)
+			return nil
+		}
+		newPath, err := relocationTarget(path, absSourceDir, absTargetDir, maxLength, opts)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			fmt.Fprintf(log, "Error placing %s: %v\n", path, err)
+			return nil
+		}
+
+		planned = append(planned, plannedMove{
+			FileMove: FileMove{OriginalPath: path, NewPath: planner.claim(newPath), FileSize: info.Size()},
+			info:     info,
+		})
+		prog.found.Add(1)
+		prog.foundBytes.Add(info.Size())
+		return nil
+	})
+
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: absSourceDir, Error: err})
+		fmt.Fprintf(log, "Error walking directory: %v\n", err)
+	}
+	return planned, errorFiles
+}
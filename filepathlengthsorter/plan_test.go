@@ -0,0 +1,197 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// longName is a directory name that pushes every file below it over the
+// limits used in these tests
+var longName = strings.Repeat("long-directory-name-", 3)
+
+// writeTree creates the files (relative path -> content) under root
+func writeTree(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readTree returns the content of every file under root by relative path
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := make(map[string]string)
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// runBoth plans the moves with a dry run, performs them with a real run and
+// fails unless both agree. It returns the moves of the real run.
+func runBoth(t *testing.T, src, dst string, maxLength int, opts MoveOptions) []FileMove {
+	t.Helper()
+	opts.Log = io.Discard
+	ctx := context.Background()
+
+	planned, errs := MoveLongPaths(ctx, src, dst, maxLength, true, opts)
+	if len(errs) > 0 {
+		t.Fatalf("dry run errors: %v", errs)
+	}
+	moved, errs := MoveLongPaths(ctx, src, dst, maxLength, false, opts)
+	if len(errs) > 0 {
+		t.Fatalf("real run errors: %v", errs)
+	}
+
+	if len(planned) != len(moved) {
+		t.Fatalf("dry run planned %d moves, real run made %d", len(planned), len(moved))
+	}
+	for i := range planned {
+		if planned[i].OriginalPath != moved[i].OriginalPath || planned[i].NewPath != moved[i].NewPath || planned[i].FileSize != moved[i].FileSize {
+			t.Errorf("move %d: dry run planned %s -> %s, real run did %s -> %s",
+				i, planned[i].OriginalPath, planned[i].NewPath, moved[i].OriginalPath, moved[i].NewPath)
+		}
+	}
+	return moved
+}
+
+func TestDryRunMatchesRealRun(t *testing.T) {
+	for _, strategy := range []string{StrategyFlatten, StrategyMirror, StrategyBucket, StrategyFit} {
+		t.Run(strategy, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeTree(t, src, map[string]string{
+				"a/" + longName + "/report.txt": "a report",
+				"b/" + longName + "/report.txt": "b report",
+				"c/" + longName + "/notes.md":   "c notes",
+				"c/" + longName + "/report.txt": "c report",
+				"short.txt":                     "stays",
+			})
+			// Files already in the target have to be planned around
+			writeTree(t, dst, map[string]string{
+				"report.txt":   "existing",
+				"report_1.txt": "existing too",
+			})
+			before := readTree(t, dst)
+
+			maxLength := len(src) + 30
+			moved := runBoth(t, src, dst, maxLength, MoveOptions{Strategy: strategy, SegmentLength: 8, Workers: 4})
+			if len(moved) != 4 {
+				t.Fatalf("moved %d files, want 4", len(moved))
+			}
+
+			after := readTree(t, dst)
+			for rel, content := range before {
+				if after[rel] != content {
+					t.Errorf("existing %s was overwritten", rel)
+				}
+			}
+			for _, move := range moved {
+				content, err := os.ReadFile(move.NewPath)
+				if err != nil {
+					t.Errorf("%s: %v", move.NewPath, err)
+					continue
+				}
+				if want := strings.Split(filepath.ToSlash(move.OriginalPath), "/"); !strings.HasPrefix(string(content), want[len(want)-3]) {
+					t.Errorf("%s holds %q, which did not come from %s", move.NewPath, content, move.OriginalPath)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(src, "short.txt")); err != nil {
+				t.Errorf("short path was moved: %v", err)
+			}
+		})
+	}
+}
+
+func TestDryRunMatchesRealRunIgnoringCase(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a/" + longName + "/Report.txt": "a",
+		"b/" + longName + "/report.TXT": "b",
+	})
+	writeTree(t, dst, map[string]string{"REPORT.txt": "existing"})
+
+	moved := runBoth(t, src, dst, len(src)+30, MoveOptions{CaseInsensitive: true, Workers: 2})
+
+	seen := map[string]bool{"report.txt": true}
+	for _, move := range moved {
+		name := strings.ToLower(filepath.Base(move.NewPath))
+		if seen[name] {
+			t.Errorf("%s clashes with another name when case is ignored", move.NewPath)
+		}
+		seen[name] = true
+	}
+	if got := readTree(t, dst)["REPORT.txt"]; got != "existing" {
+		t.Errorf("existing REPORT.txt now holds %q", got)
+	}
+}
+
+func TestPlannerClaim(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "", "B.txt": ""})
+
+	tests := []struct {
+		foldCase bool
+		claims   []string
+		want     []string
+	}{
+		{false, []string{"a.txt", "a.txt", "b.txt"}, []string{"a_1.txt", "a_2.txt", "b.txt"}},
+		{true, []string{"A.TXT", "b.txt", "c"}, []string{"A_1.TXT", "b_1.txt", "c"}},
+	}
+	for _, tt := range tests {
+		planner := newMovePlanner(tt.foldCase)
+		for i, name := range tt.claims {
+			got := filepath.Base(planner.claim(filepath.Join(dir, name)))
+			if got != tt.want[i] {
+				t.Errorf("foldCase=%v: claim %d of %s = %s, want %s", tt.foldCase, i, name, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPlanMovesNamesTheMeasureRejects(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{
+		"a-name-far-longer-than-twenty.txt": "long segment",
+		"CON.txt":                           "reserved",
+		"bad:dir/notes.txt":                 "forbidden character above",
+		"fine.txt":                          "stays",
+	})
+	measure := measureProfiles["windows"]
+	measure.SegmentLimit = 20
+
+	// Every path is well within the total limit
+	moved := runBoth(t, src, dst, 4096, MoveOptions{Measure: measure, Strategy: StrategyMirror, Workers: 2})
+	if len(moved) != 3 {
+		t.Fatalf("moved %d files, want 3: %v", len(moved), moved)
+	}
+	for _, move := range moved {
+		if measure.breaksComponentRules(move.NewPath, dst) {
+			t.Errorf("%s was moved to %s, which still breaks the rules", move.OriginalPath, move.NewPath)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(src, "fine.txt")); err != nil {
+		t.Errorf("fine.txt was moved: %v", err)
+	}
+}
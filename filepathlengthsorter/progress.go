@@ -0,0 +1,111 @@
+package filepathlengthsorter
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress line is redrawn
+const progressInterval = 250 * time.Millisecond
+
+// progress counts what MoveLongPaths has done so far and, when it has
+// somewhere to write, redraws a one-line summary of it. The counters are
+// updated from several goroutines.
+type progress struct {
+	scanned    atomic.Int64 // files looked at
+	found      atomic.Int64 // files with long paths
+	foundBytes atomic.Int64
+	moved      atomic.Int64 // files moved so far
+	movedBytes atomic.Int64
+	copying    atomic.Int64 // bytes copied of moves still in flight
+
+	mu        sync.Mutex
+	moveStart time.Time // zero while scanning
+
+	out   io.Writer
+	width int // longest line drawn, so shorter ones can blank it out
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// newProgress starts redrawing the progress line on out. With a nil out
+// the counters are kept but nothing is drawn.
+func newProgress(out io.Writer) *progress {
+	p := &progress{out: out}
+	if out == nil {
+		return p
+	}
+
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				p.draw("\r")
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// startMoving switches from counting scanned files to estimating how long
+// the moves will take
+func (p *progress) startMoving() {
+	p.mu.Lock()
+	p.moveStart = time.Now()
+	p.mu.Unlock()
+}
+
+// Stop draws the final state and leaves it on its own line
+func (p *progress) Stop() {
+	if p.out == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+	p.draw("\n")
+}
+
+// draw writes the current line followed by end. Ending with a carriage
+// return keeps other output from starting in the middle of the line.
+func (p *progress) draw(end string) {
+	line := p.line()
+	p.width = max(p.width, len(line))
+	fmt.Fprintf(p.out, "\r%-*s%s", p.width, line, end)
+}
+
+func (p *progress) line() string {
+	p.mu.Lock()
+	moveStart := p.moveStart
+	p.mu.Unlock()
+
+	found, foundBytes := p.found.Load(), p.foundBytes.Load()
+	if moveStart.IsZero() {
+		return fmt.Sprintf("Scanned %d files, %d too long (%s)", p.scanned.Load(), found, FormatFileSize(foundBytes))
+	}
+
+	moved := p.moved.Load()
+	doneBytes := p.movedBytes.Load() + p.copying.Load()
+	line := fmt.Sprintf("Moved %d of %d files, %s of %s", moved, found, FormatFileSize(doneBytes), FormatFileSize(foundBytes))
+
+	// Estimate by bytes, or by files when they are all empty
+	fraction := float64(moved) / float64(max(found, 1))
+	if foundBytes > 0 {
+		fraction = float64(doneBytes) / float64(foundBytes)
+	}
+	if fraction > 0 && fraction < 1 {
+		elapsed := time.Since(moveStart)
+		eta := time.Duration(float64(elapsed) * (1 - fraction) / fraction)
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
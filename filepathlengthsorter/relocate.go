@@ -0,0 +1,143 @@
+package filepathlengthsorter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Strategies for where MoveLongPaths puts a file whose path is too long
+const (
+	// StrategyFlatten moves every file straight into the target directory
+	// under its base name
+	StrategyFlatten = "flatten"
+	// StrategyMirror recreates the file's relative directory tree under the
+	// target, with every directory name cut to MoveOptions.SegmentLength
+	StrategyMirror = "mirror"
+	// StrategyBucket spreads files over hash-named directories under the
+	// target and keeps a sidecar index of where each came from
+	StrategyBucket = "bucket"
+	// StrategyFit recreates the relative tree under the target, shortening
+	// the longest path segments until the new path fits under the limit
+	StrategyFit = "fit"
+)
+
+// bucketIndexName is the sidecar index written into the target directory by
+// StrategyBucket. It has the same format as a manifest.
+const bucketIndexName = "long-path-index.jsonl"
+
+// minSegmentLength is the shortest a segment is cut to: a few characters of
+// the original name followed by "~" and a 6 character hash
+const minSegmentLength = 10
+
+// ValidStrategy reports whether name is one of the relocation strategies
+func ValidStrategy(name string) bool {
+	switch name {
+	case StrategyFlatten, StrategyMirror, StrategyBucket, StrategyFit:
+		return true
+	}
+	return false
+}
+
+// relocationTarget returns where path (inside sourceDir) goes under
+// targetDir for the strategy in opts, before name conflicts are resolved.
+// Names the component rules of opts.Measure reject are fixed on the way.
+func relocationTarget(path, sourceDir, targetDir string, maxLength int, opts MoveOptions) (string, error) {
+	rel, err := filepath.Rel(sourceDir, path)
+	if err != nil {
+		return "", err
+	}
+	rel = opts.Measure.fixPath(rel)
+
+	switch opts.Strategy {
+	case StrategyMirror:
+		return mirrorPath(rel, targetDir, opts.SegmentLength), nil
+	case StrategyBucket:
+		return bucketPath(rel, targetDir), nil
+	case StrategyFit:
+		return fitPath(rel, targetDir, maxLength, opts.Measure)
+	default:
+		return filepath.Join(targetDir, filepath.Base(rel)), nil
+	}
+}
+
+// mirrorPath places rel under targetDir with each directory name shortened
+// to at most segmentLength bytes. The file name itself is kept.
+func mirrorPath(rel, targetDir string, segmentLength int) string {
+	segments := strings.Split(rel, string(filepath.Separator))
+	for i := range segments[:len(segments)-1] {
+		segments[i] = shortenSegment(segments[i], segmentLength)
+	}
+	return filepath.Join(targetDir, filepath.Join(segments...))
+}
+
+// bucketPath places the file under targetDir/xx/yy, where xxyy are the first
+// hex digits of the hash of its relative path
+func bucketPath(rel, targetDir string) string {
+	sum := sha256.Sum256([]byte(filepath.ToSlash(rel)))
+	bucket := hex.EncodeToString(sum[:2])
+	return filepath.Join(targetDir, bucket[:2], bucket[2:], filepath.Base(rel))
+}
+
+// fitPath places rel under targetDir and then repeatedly shortens its
+// longest segment until the whole path measures at most maxLength
+func fitPath(rel, targetDir string, maxLength int, measure PathMeasure) (string, error) {
+	segments := strings.Split(rel, string(filepath.Separator))
+
+	for {
+		newPath := filepath.Join(targetDir, filepath.Join(segments...))
+		excess := measure.Count(newPath) - maxLength
+		if excess <= 0 {
+			return newPath, nil
+		}
+
+		longest := -1
+		for i, segment := range segments {
+			if len(segment) > minSegmentLength && (longest < 0 || len(segment) > len(segments[longest])) {
+				longest = i
+			}
+		}
+		if longest < 0 {
+			return "", fmt.Errorf("cannot shorten %s to fit in %d characters under %s", rel, maxLength, targetDir)
+		}
+
+		segments[longest] = shortenSegment(segments[longest], max(len(segments[longest])-excess, minSegmentLength))
+	}
+}
+
+// shortenSegment cuts a single path segment down to at most length bytes.
+// The result ends in "~" and a short hash of the full name so different
+// long names with the same prefix stay distinct, and file extensions are
+// kept when there is room.
+func shortenSegment(segment string, length int) string {
+	if len(segment) <= length {
+		return segment
+	}
+	length = max(length, minSegmentLength)
+
+	sum := sha256.Sum256([]byte(segment))
+	suffix := "~" + hex.EncodeToString(sum[:3])
+
+	ext := filepath.Ext(segment)
+	if len(ext) > length-len(suffix)-3 {
+		ext = ""
+	}
+	stem := truncateUTF8(strings.TrimSuffix(segment, ext), length-len(suffix)-len(ext))
+	// Windows does not allow names ending in a space or a dot
+	stem = strings.TrimRight(stem, " .")
+	return stem + suffix + ext
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,235 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// How far an interrupted run got with a move, found out when resuming
+const (
+	resumeNone         = iota // nothing happened yet
+	resumeRecord              // the file is in place but not in the manifest
+	resumeRemoveSource        // the copy is complete but the original remains
+)
+
+// RunState is the plan of a real run, saved before the first file is moved
+// so that -resume can carry on with exactly the same targets. What has
+// been done is read back from the manifest.
+type RunState struct {
+	Source       string     `json:"source"`
+	Target       string     `json:"target"`
+	MaxLength    int        `json:"max_length"`
+	Strategy     string     `json:"strategy,omitempty"`
+	Manifest     string     `json:"manifest"`
+	PartialToken string     `json:"partial_token,omitempty"` // names the temporary copies of the run, see partialPath
+	CreatedAt    time.Time  `json:"created_at"`
+	Moves        []FileMove `json:"moves"`
+}
+
+// DefaultStatePath names the state file after the manifest it goes with
+func DefaultStatePath(manifestPath string) string {
+	return strings.TrimSuffix(manifestPath, filepath.Ext(manifestPath)) + ".state.json"
+}
+
+// writeState saves state to path, replacing any earlier state atomically
+func writeState(path string, state RunState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*"+PartialSuffix)
+	if err != nil {
+		return err
+	}
+	partial := file.Name()
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(partial)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(partial)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// ReadState loads the state saved at path
+func ReadState(path string) (RunState, error) {
+	var state RunState
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, fmt.Errorf("%s: %w", path, err)
+	}
+	return state, nil
+}
+
+// finishState removes the state file once nothing is left to do, and
+// otherwise says how to carry on
+func finishState(path string, pending int, log io.Writer) {
+	if path == "" {
+		return
+	}
+	if pending == 0 {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(log, "Error removing state file: %v\n", err)
+		}
+		return
+	}
+	fmt.Fprintf(log, "%d files were not moved. Progress is saved in %s, run again with -resume %s to carry on.\n", pending, path, path)
+}
+
+// ResumeMoves carries on with the plan in state. Moves the manifest
+// already records are skipped, and moves that were cut short are finished
+// or started over as what is on disk shows.
+func ResumeMoves(ctx context.Context, state RunState, opts MoveOptions) ([]FileMove, []FileMoveError) {
+	var errorFiles []FileMoveError
+	log := opts.Log
+	if log == nil {
+		log = os.Stdout
+	}
+
+	// Copies are made under the temporary names the interrupted run used.
+	// A state that lacks a token gets a new one.
+	opts.partialToken = state.PartialToken
+	if opts.partialToken == "" {
+		opts.partialToken = newPartialToken()
+	}
+
+	fmt.Fprintf(log, "\nMode: RESUMING\n")
+	fmt.Fprintf(log, "Processing source directory: %s\n", state.Source)
+	fmt.Fprintf(log, "Target directory: %s\n", state.Target)
+	fmt.Fprintf(log, "Planned at: %s\n", state.CreatedAt.Local().Format(time.DateTime))
+
+	done := make(map[FileMove]bool)
+	recorded, err := readManifest(state.Manifest)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		errorFiles = append(errorFiles, FileMoveError{Path: state.Manifest, Error: err})
+		fmt.Fprintf(log, "Error reading manifest: %v\n", err)
+		return nil, errorFiles
+	}
+	for _, move := range recorded {
+		done[FileMove{OriginalPath: move.OriginalPath, NewPath: move.NewPath}] = true
+	}
+
+	var planned []plannedMove
+	alreadyDone := 0
+	for _, move := range state.Moves {
+		if done[FileMove{OriginalPath: move.OriginalPath, NewPath: move.NewPath}] {
+			alreadyDone++
+			continue
+		}
+		p, err := reconcileMove(move, state.PartialToken)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: move.OriginalPath, Error: err})
+			fmt.Fprintf(log, "Cannot resume %s: %v\n", move.OriginalPath, err)
+			continue
+		}
+		planned = append(planned, p)
+	}
+	fmt.Fprintf(log, "%d of %d moves were already done, %d left\n\n", alreadyDone, len(state.Moves), len(planned))
+
+	prog := newProgress(opts.Progress)
+	for _, p := range planned {
+		prog.found.Add(1)
+		prog.foundBytes.Add(p.FileSize)
+	}
+	movedFiles, applyErrors := applyMoves(ctx, state.Target, planned, opts, prog, log)
+	errorFiles = append(errorFiles, applyErrors...)
+	finishState(opts.StatePath, len(state.Moves)-alreadyDone-len(movedFiles), log)
+	return movedFiles, errorFiles
+}
+
+// reconcileMove works out how far an interrupted run got with move from
+// what exists on disk. A partial copy is thrown away, since it cannot be
+// told apart from a complete one. Only the copy named with token, which the
+// interrupted run created, is removed; states without a token leave any
+// partial copy alone.
+func reconcileMove(move FileMove, token string) (plannedMove, error) {
+	if token != "" {
+		if err := os.Remove(partialPath(move.NewPath, token)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return plannedMove{}, fmt.Errorf("removing partial copy: %w", err)
+		}
+	}
+
+	srcInfo, srcErr := os.Lstat(move.OriginalPath)
+	dstInfo, dstErr := os.Lstat(move.NewPath)
+	if srcErr != nil && !errors.Is(srcErr, os.ErrNotExist) {
+		return plannedMove{}, srcErr
+	}
+	if dstErr != nil && !errors.Is(dstErr, os.ErrNotExist) {
+		return plannedMove{}, dstErr
+	}
+
+	switch {
+	case srcErr == nil && dstErr != nil:
+		move.FileSize = srcInfo.Size()
+		return plannedMove{FileMove: move, info: srcInfo}, nil
+	case srcErr != nil && dstErr == nil:
+		move.FileSize = dstInfo.Size()
+		return plannedMove{FileMove: move, info: dstInfo, resume: resumeRecord}, nil
+	case srcErr == nil && dstErr == nil:
+		if srcInfo.Size() != dstInfo.Size() {
+			return plannedMove{}, fmt.Errorf("%s already exists and differs from the original", move.NewPath)
+		}
+		move.FileSize = srcInfo.Size()
+		return plannedMove{FileMove: move, info: srcInfo, resume: resumeRemoveSource}, nil
+	default:
+		return plannedMove{}, fmt.Errorf("neither %s nor %s exists", move.OriginalPath, move.NewPath)
+	}
+}
+
+// finishCopy deletes the original of a move whose copy was completed by an
+// interrupted run. Both are hashed first whether verifying or not: a copy
+// of the right size can still hold the wrong content, and the original is
+// the only good copy left.
+func finishCopy(p plannedMove, opts MoveOptions) (string, error) {
+	checksum, err := HashFile(p.NewPath)
+	if err != nil {
+		return "", fmt.Errorf("computing checksum of %s: %w", p.NewPath, err)
+	}
+	srcChecksum, err := HashFile(p.OriginalPath)
+	if err != nil {
+		return "", fmt.Errorf("computing checksum of %s: %w", p.OriginalPath, err)
+	}
+	if srcChecksum != checksum {
+		return "", fmt.Errorf("%s already exists and differs from the original", p.NewPath)
+	}
+
+	if err := os.Remove(p.OriginalPath); err != nil {
+		return "", fmt.Errorf("removing original file: %w", err)
+	}
+	if !opts.Verify {
+		return "", nil
+	}
+	return checksum, nil
+}
+
+// checksumIfVerifying returns the SHA-256 of path when opts asks for
+// verification, and nothing otherwise
+func checksumIfVerifying(path string, opts MoveOptions) (string, error) {
+	if !opts.Verify {
+		return "", nil
+	}
+	checksum, err := HashFile(path)
+	if err != nil {
+		return "", fmt.Errorf("computing checksum of %s: %w", path, err)
+	}
+	return checksum, nil
+}
@@ -0,0 +1,96 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyAndRemoveKeepsFilesInTheWay(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"report.txt": "new content"})
+	writeTree(t, dst, map[string]string{"report.txt" + PartialSuffix: "the user's own file"})
+
+	from, to := filepath.Join(src, "report.txt"), filepath.Join(dst, "report.txt")
+	info, err := os.Lstat(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := MoveOptions{Verify: true, partialToken: newPartialToken()}
+	if _, err := copyAndRemove(context.Background(), from, to, info, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"report.txt": "new content", "report.txt" + PartialSuffix: "the user's own file"}
+	got := readTree(t, dst)
+	if len(got) != len(want) {
+		t.Errorf("target holds %v, want %v", got, want)
+	}
+	for rel, content := range want {
+		if got[rel] != content {
+			t.Errorf("%s holds %q, want %q", rel, got[rel], content)
+		}
+	}
+
+	// A temporary copy that is already there is never truncated
+	writeTree(t, src, map[string]string{"other.txt": "more"})
+	from, to = filepath.Join(src, "other.txt"), filepath.Join(dst, "other.txt")
+	writeTree(t, dst, map[string]string{filepath.Base(partialPath(to, opts.partialToken)): "in the way"})
+	info, _ = os.Lstat(from)
+	if _, err := copyAndRemove(context.Background(), from, to, info, opts); err == nil {
+		t.Error("copied over a file in the way")
+	}
+	if content, _ := os.ReadFile(partialPath(to, opts.partialToken)); string(content) != "in the way" {
+		t.Errorf("file in the way holds %q", content)
+	}
+}
+
+func TestReconcileOnlyRemovesOwnPartialCopies(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"report.txt": "content"})
+	move := FileMove{OriginalPath: filepath.Join(src, "report.txt"), NewPath: filepath.Join(dst, "report.txt")}
+	token := newPartialToken()
+	writeTree(t, dst, map[string]string{
+		"report.txt" + PartialSuffix:                         "the user's own file",
+		filepath.Base(partialPath(move.NewPath, token)):      "half a copy",
+		filepath.Base(partialPath(move.NewPath, "otherrun")): "another run's copy",
+	})
+
+	p, err := reconcileMove(move, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.resume != resumeNone {
+		t.Errorf("resume = %d, want resumeNone", p.resume)
+	}
+	if _, err := os.Stat(partialPath(move.NewPath, token)); !os.IsNotExist(err) {
+		t.Errorf("own partial copy was kept: %v", err)
+	}
+	for _, name := range []string{"report.txt" + PartialSuffix, filepath.Base(partialPath(move.NewPath, "otherrun"))} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err != nil {
+			t.Errorf("%s was removed: %v", name, err)
+		}
+	}
+}
+
+func TestResumeKeepsOriginalWhenCopyDiffers(t *testing.T) {
+	src, dst := t.TempDir(), t.TempDir()
+	writeTree(t, src, map[string]string{"report.txt": "original"})
+	writeTree(t, dst, map[string]string{"report.txt": "damaged!"}) // same size
+	move := FileMove{OriginalPath: filepath.Join(src, "report.txt"), NewPath: filepath.Join(dst, "report.txt")}
+
+	p, err := reconcileMove(move, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.resume != resumeRemoveSource {
+		t.Fatalf("resume = %d, want resumeRemoveSource", p.resume)
+	}
+	if _, err := finishCopy(p, MoveOptions{}); err == nil {
+		t.Error("finished a copy with the wrong content")
+	}
+	if content, err := os.ReadFile(move.OriginalPath); err != nil || string(content) != "original" {
+		t.Errorf("original holds %q: %v", content, err)
+	}
+}
@@ -0,0 +1,404 @@
+package filepathlengthsorter
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Ways of proposing a shorter name for a path segment
+const (
+	// MethodTruncate cuts the name and appends a short hash
+	MethodTruncate = "truncate"
+	// MethodVowels drops the vowels after the first letter, then truncates
+	// if that is not enough
+	MethodVowels = "vowels"
+	// MethodRules applies user supplied abbreviations, then truncates if
+	// that is not enough
+	MethodRules = "rules"
+)
+
+// Rename is one entry of an in-place shortening plan
+type Rename struct {
+	Path    string `json:"path"`     // where the entry is before any rename
+	NewName string `json:"new_name"` // its new base name
+	NewPath string `json:"new_path"` // where it ends up once the whole plan is applied
+	IsDir   bool   `json:"is_dir"`
+}
+
+// Abbreviation replaces every case-insensitive occurrence of a word
+type Abbreviation struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// LoadAbbreviations reads "long=short" rules, one per line. Blank lines and
+// lines starting with # are ignored. Longer words are applied first.
+func LoadAbbreviations(path string) ([]Abbreviation, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	type rule struct{ long, short string }
+	var rules []rule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		long, short, ok := strings.Cut(text, "=")
+		if !ok || strings.TrimSpace(long) == "" {
+			return nil, fmt.Errorf("%s line %d: expected long=short", path, line)
+		}
+		rules = append(rules, rule{strings.TrimSpace(long), strings.TrimSpace(short)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(rules, func(a, b rule) int { return len(b.long) - len(a.long) })
+	abbreviations := make([]Abbreviation, len(rules))
+	for i, r := range rules {
+		abbreviations[i] = Abbreviation{
+			pattern:     regexp.MustCompile("(?i)" + regexp.QuoteMeta(r.long)),
+			replacement: r.short,
+		}
+	}
+	return abbreviations, nil
+}
+
+// shortener plans in-place renames so every file under root fits in
+// maxLength. Decisions are keyed by the original path of each segment so a
+// directory shared by several long paths is only renamed once.
+type shortener struct {
+	root          string
+	maxLength     int
+	measure       PathMeasure
+	method        string
+	abbreviations []Abbreviation
+
+	newNames map[string]string          // original path -> new base name
+	taken    map[string]map[string]bool // parent directory -> lower-cased names in use
+}
+
+func newShortener(root string, maxLength int, measure PathMeasure, method string, abbreviations []Abbreviation) *shortener {
+	return &shortener{
+		root:          root,
+		maxLength:     maxLength,
+		measure:       measure,
+		method:        method,
+		abbreviations: abbreviations,
+		newNames:      make(map[string]string),
+		taken:         make(map[string]map[string]bool),
+	}
+}
+
+// names returns the set of names in use in dir: everything on disk plus
+// every name already proposed for it. Names are compared case-insensitively
+// so the plan also works on case-insensitive filesystems.
+func (s *shortener) names(dir string) map[string]bool {
+	if names, ok := s.taken[dir]; ok {
+		return names
+	}
+
+	names := make(map[string]bool)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		names[strings.ToLower(entry.Name())] = true
+	}
+	s.taken[dir] = names
+	return names
+}
+
+// plan renames the segments whose names the component rules reject, then
+// shortens the segments of path, longest first, until the renamed path fits
+// in maxLength. A segment may be shortened again for a later path; its final
+// name is whatever was proposed last.
+func (s *shortener) plan(path string) error {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil {
+		return err
+	}
+	segments := strings.Split(rel, string(filepath.Separator))
+
+	// originals[i] is the path of segment i before any rename
+	originals := make([]string, len(segments))
+	for i := range segments {
+		originals[i] = filepath.Join(s.root, filepath.Join(segments[:i+1]...))
+	}
+	for i, original := range originals {
+		if _, renamed := s.newNames[original]; !renamed && len(s.measure.ComponentProblems(segments[i])) > 0 {
+			s.rename(original, s.measure.fixComponent(segments[i]))
+		}
+	}
+
+	for {
+		current := make([]string, len(segments))
+		for i, original := range originals {
+			current[i] = cmp.Or(s.newNames[original], segments[i])
+		}
+
+		excess := s.measure.Length(filepath.Join(s.root, filepath.Join(current...)), s.root) - s.maxLength
+		if excess <= 0 {
+			return nil
+		}
+
+		// Pick the longest segment, remembering the runner-up so the longest
+		// is only cut down to its length and the shortening is spread out
+		longest, second := -1, 0
+		for i, name := range current {
+			if len(name) <= minSegmentLength {
+				continue
+			}
+			if longest < 0 || len(name) > len(current[longest]) {
+				if longest >= 0 {
+					second = len(current[longest])
+				}
+				longest = i
+			} else {
+				second = max(second, len(name))
+			}
+		}
+		if longest < 0 {
+			return fmt.Errorf("cannot shorten path to %d characters", s.maxLength)
+		}
+
+		length := max(len(current[longest])-excess, second)
+		if length >= len(current[longest]) {
+			length = len(current[longest]) - 1
+		}
+
+		s.rename(originals[longest], s.measure.fixComponent(s.propose(segments[longest], length)))
+	}
+}
+
+// rename records that the entry at original gets name, made unique in its
+// directory
+func (s *shortener) rename(original, name string) {
+	parent := filepath.Dir(original)
+	name = s.unique(parent, name)
+	s.names(parent)[strings.ToLower(name)] = true
+	s.newNames[original] = name
+}
+
+// propose returns a shorter name for segment of at most length bytes using
+// the configured method
+func (s *shortener) propose(segment string, length int) string {
+	length = max(length, minSegmentLength)
+
+	candidate := segment
+	switch s.method {
+	case MethodVowels:
+		candidate = removeVowels(segment)
+	case MethodRules:
+		for _, abbr := range s.abbreviations {
+			candidate = abbr.pattern.ReplaceAllLiteralString(candidate, abbr.replacement)
+		}
+	}
+
+	if candidate != "" && len(candidate) <= length {
+		return candidate
+	}
+	return shortenSegment(candidate, length)
+}
+
+// unique appends _1, _2, ... before the extension of name until it does
+// not clash with anything in dir
+func (s *shortener) unique(dir, name string) string {
+	names := s.names(dir)
+	if !names[strings.ToLower(name)] {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for counter := 1; ; counter++ {
+		candidate := fmt.Sprintf("%s_%d%s", stem, counter, ext)
+		if !names[strings.ToLower(candidate)] {
+			return candidate
+		}
+	}
+}
+
+// removeVowels drops every vowel after the first character of the name,
+// keeping the extension intact
+func removeVowels(name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+
+	var b strings.Builder
+	for i, r := range stem {
+		if i > 0 && strings.ContainsRune("aeiouAEIOU", r) {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String() + ext
+}
+
+// renames turns the decisions into a plan, ordered deepest first so that
+// every entry can be renamed before its parent directory is
+func (s *shortener) renames() []Rename {
+	var plan []Rename
+	for original, newName := range s.newNames {
+		rel, _ := filepath.Rel(s.root, original)
+		segments := strings.Split(rel, string(filepath.Separator))
+
+		final := s.root
+		for i := range segments {
+			prefix := filepath.Join(s.root, filepath.Join(segments[:i+1]...))
+			final = filepath.Join(final, cmp.Or(s.newNames[prefix], segments[i]))
+		}
+
+		info, err := os.Lstat(original)
+		plan = append(plan, Rename{
+			Path:    original,
+			NewName: newName,
+			NewPath: final,
+			IsDir:   err == nil && info.IsDir(),
+		})
+	}
+
+	slices.SortFunc(plan, func(a, b Rename) int {
+		depthA := strings.Count(a.Path, string(filepath.Separator))
+		depthB := strings.Count(b.Path, string(filepath.Separator))
+		if depthA != depthB {
+			return depthB - depthA
+		}
+		return strings.Compare(a.Path, b.Path)
+	})
+	return plan
+}
+
+// ShortenOptions holds the optional behaviour of PlanShortening and
+// ApplyShortening
+type ShortenOptions struct {
+	// Measure decides how path lengths are counted. The zero value counts
+	// bytes of the absolute path.
+	Measure PathMeasure
+	// Method is one of the Method constants. Empty means MethodTruncate.
+	Method string
+	// Abbreviations are the rules MethodRules applies
+	Abbreviations []Abbreviation
+	// ManifestPath is where ApplyShortening journals every rename so that
+	// it can be undone later. No manifest is written when it is empty.
+	ManifestPath string
+	// Log is where problems and renames are described. Nil means stdout.
+	Log io.Writer
+}
+
+// log returns where opts describes what is being done
+func (opts ShortenOptions) log() io.Writer {
+	if opts.Log == nil {
+		return os.Stdout
+	}
+	return opts.Log
+}
+
+// PlanShortening walks sourceDir and plans the renames needed to bring every
+// path under maxLength, and every name past the component rules of
+// opts.Measure, without moving anything out of its directory
+func PlanShortening(sourceDir string, maxLength int, opts ShortenOptions) ([]Rename, []FileMoveError) {
+	var errorFiles []FileMoveError
+	log := opts.log()
+	measure := opts.Measure
+
+	absSourceDir, err := filepath.Abs(sourceDir)
+	if err != nil {
+		return nil, []FileMoveError{{Path: sourceDir, Error: err}}
+	}
+
+	s := newShortener(absSourceDir, maxLength, measure, cmp.Or(opts.Method, MethodTruncate), opts.Abbreviations)
+	err = filepath.Walk(absSourceDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			fmt.Fprintf(log, "Error accessing path %s: %v\n", path, err)
+			return nil // Continue walking
+		}
+		if path == absSourceDir {
+			return nil
+		}
+		tooLong := !info.IsDir() && measure.Length(path, absSourceDir) > maxLength
+		if !tooLong && len(measure.ComponentProblems(info.Name())) == 0 {
+			return nil
+		}
+
+		if err := s.plan(path); err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: path, Error: err})
+			fmt.Fprintf(log, "Error shortening %s: %v\n", path, err)
+		}
+		return nil
+	})
+	if err != nil {
+		errorFiles = append(errorFiles, FileMoveError{Path: absSourceDir, Error: err})
+		fmt.Fprintf(log, "Error walking directory: %v\n", err)
+	}
+
+	return s.renames(), errorFiles
+}
+
+// ApplyShortening performs the renames of plan in order, journalling each
+// one to opts.ManifestPath (if set) so that undo can reverse them
+func ApplyShortening(plan []Rename, opts ShortenOptions) ([]FileMove, []FileMoveError) {
+	var done []FileMove
+	var errorFiles []FileMoveError
+	log := opts.log()
+
+	var journal *manifest
+	if opts.ManifestPath != "" {
+		var err error
+		journal, err = openManifest(opts.ManifestPath)
+		if err != nil {
+			return nil, []FileMoveError{{Path: opts.ManifestPath, Error: err}}
+		}
+		defer journal.Close()
+	}
+
+	for _, rename := range plan {
+		newPath := filepath.Join(filepath.Dir(rename.Path), rename.NewName)
+
+		info, err := os.Lstat(rename.Path)
+		if err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: rename.Path, Error: err})
+			fmt.Fprintf(log, "Error renaming %s: %v\n", rename.Path, err)
+			continue
+		}
+		if _, err := os.Lstat(newPath); !errors.Is(err, os.ErrNotExist) {
+			errorFiles = append(errorFiles, FileMoveError{Path: rename.Path, Error: fmt.Errorf("%s already exists", newPath)})
+			fmt.Fprintf(log, "Conflict: %s already exists\n", newPath)
+			continue
+		}
+
+		if err := os.Rename(rename.Path, newPath); err != nil {
+			errorFiles = append(errorFiles, FileMoveError{Path: rename.Path, Error: err})
+			fmt.Fprintf(log, "Error renaming %s: %v\n", rename.Path, err)
+			continue
+		}
+
+		move := FileMove{OriginalPath: rename.Path, NewPath: newPath, MovedAt: time.Now().UTC()}
+		if !info.IsDir() {
+			move.FileSize = info.Size()
+		}
+		done = append(done, move)
+		fmt.Fprintf(log, "Renamed: %s\nTo: %s\n\n", rename.Path, rename.NewName)
+
+		if journal != nil {
+			if err := journal.Record(move); err != nil {
+				errorFiles = append(errorFiles, FileMoveError{Path: rename.Path, Error: fmt.Errorf("recording rename in manifest: %w", err)})
+				fmt.Fprintf(log, "Error recording %s in manifest: %v\n", rename.Path, err)
+			}
+		}
+	}
+	return done, errorFiles
+}
@@ -0,0 +1,111 @@
+package filepathlengthsorter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SnapshotEntry is one file of a snapshot: a tree recorded so that it can
+// be compared without being mounted
+type SnapshotEntry struct {
+	Path    string      `json:"path"` // relative to the tree, with forward slashes
+	Size    int64       `json:"size"`
+	ModTime time.Time   `json:"mtime"`
+	Mode    fs.FileMode `json:"mode"`
+	SHA256  string      `json:"sha256,omitempty"` // only when taken with hashes, and only for regular files
+}
+
+// Info describes the recorded file the way os.Lstat would have
+func (e SnapshotEntry) Info() fs.FileInfo {
+	return snapshotInfo{e}
+}
+
+// snapshotInfo is a SnapshotEntry as an fs.FileInfo
+type snapshotInfo struct {
+	entry SnapshotEntry
+}
+
+func (i snapshotInfo) Name() string       { return filepath.Base(filepath.FromSlash(i.entry.Path)) }
+func (i snapshotInfo) Size() int64        { return i.entry.Size }
+func (i snapshotInfo) Mode() fs.FileMode  { return i.entry.Mode }
+func (i snapshotInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i snapshotInfo) IsDir() bool        { return false }
+func (i snapshotInfo) Sys() any           { return nil }
+
+// WriteSnapshot records every file below dir that filter lets through to w
+// as gzip-compressed JSON lines, one SnapshotEntry per file in walk order.
+// With hash set regular files are also hashed, which reads all of them.
+// It returns the number of files recorded.
+func WriteSnapshot(ctx context.Context, w io.Writer, dir string, filter Filter, hash bool) (int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	count := 0
+	err := filter.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		entry := SnapshotEntry{Path: filepath.ToSlash(rel), Size: info.Size(), ModTime: info.ModTime(), Mode: info.Mode()}
+		if hash && info.Mode().IsRegular() {
+			if entry.SHA256, err = HashFile(path); err != nil {
+				return err
+			}
+		}
+		count++
+		return enc.Encode(entry)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, zw.Close()
+}
+
+// ReadSnapshot returns every file recorded in the snapshot at path
+func ReadSnapshot(path string) ([]SnapshotEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %w", path, err)
+	}
+	var entries []SnapshotEntry
+	dec := json.NewDecoder(zr)
+	for {
+		var entry SnapshotEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s entry %d: %w", path, len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+}
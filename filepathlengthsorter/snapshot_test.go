@@ -0,0 +1,78 @@
+package filepathlengthsorter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	writeTree(t, root, map[string]string{
+		"a.txt":         "hello",
+		"sub/b.txt":     "world!",
+		"build/out.bin": "skipped",
+	})
+	exclude, err := ParsePattern("build/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "snapshot.jsonl.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := WriteSnapshot(context.Background(), file, root, Filter{Exclude: []Pattern{exclude}}, true)
+	file.Close()
+	if err != nil || count != 2 {
+		t.Fatalf("recorded %d files: %v", count, err)
+	}
+
+	entries, err := ReadSnapshot(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("read %d entries, want 2: %v", len(entries), entries)
+	}
+	for _, entry := range entries {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(entry.Path)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := entry.Info()
+		if got.Size() != info.Size() || !got.ModTime().Equal(info.ModTime()) || got.Mode() != info.Mode() || got.Name() != info.Name() {
+			t.Errorf("%s recorded as %d %v %v, is %d %v %v", entry.Path, got.Size(), got.ModTime(), got.Mode(), info.Size(), info.ModTime(), info.Mode())
+		}
+		sum, _ := HashFile(filepath.Join(root, filepath.FromSlash(entry.Path)))
+		if entry.SHA256 != sum {
+			t.Errorf("%s recorded with hash %q, want %q", entry.Path, entry.SHA256, sum)
+		}
+	}
+}
+
+func TestFilterWants(t *testing.T) {
+	var filter Filter
+	for _, s := range []string{"build/", "*.tmp", "!keep.tmp"} {
+		p, err := ParsePattern(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter.Exclude = append(filter.Exclude, p)
+	}
+	info := SnapshotEntry{Size: 1}.Info()
+	for rel, want := range map[string]bool{
+		"a.txt":          true,
+		"build/a.txt":    false,
+		"src/build/x.go": false,
+		"x.tmp":          false,
+		"keep.tmp":       true,
+		"build":          true, // a file, not the directory
+	} {
+		if got := filter.Wants(rel, info); got != want {
+			t.Errorf("Wants(%q) = %v, want %v", rel, got, want)
+		}
+	}
+}
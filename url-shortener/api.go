@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+// actorKey holds the name of the authenticated user in a request context
+const actorKey contextKey = iota
+
+// actorFrom returns the user who made r, or "anonymous" for requests that
+// did not go through requireAPIKey such as the web form
+func actorFrom(r *http.Request) string {
+	if name, ok := r.Context().Value(actorKey).(string); ok {
+		return name
+	}
+	return "anonymous"
+}
+
+// maxImportLinks caps how many links a single import request may create
+const maxImportLinks = 1000
+
+// loadAPIKeys reads a JSON object mapping user names to API keys
+func loadAPIKeys(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var keys map[string]string
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for name, key := range keys {
+		if key == "" {
+			return fmt.Errorf("user %q in %s has an empty API key", name, path)
+		}
+		apiKeys[key] = name
+	}
+	return nil
+}
+
+// requireAPIKey rejects requests that do not carry a known API key as a
+// bearer token. The API is disabled when no keys are configured.
+func requireAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(apiKeys) == 0 {
+			writeError(w, http.StatusForbidden, "API is disabled")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		name, valid := lookupAPIKey(token)
+		if !ok || !valid {
+			writeError(w, http.StatusUnauthorized, "invalid or missing API key")
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey, name)))
+	}
+}
+
+// requireAdmin is requireAPIKey restricted to the users listed in admins
+func requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return requireAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		if !admins[actorFrom(r)] {
+			writeError(w, http.StatusForbidden, "admin access required")
+			return
+		}
+		next(w, r)
+	})
+}
+
+// lookupAPIKey compares token against every key in constant time and
+// returns the name of its owner
+func lookupAPIKey(token string) (string, bool) {
+	name, valid := "", false
+	for key, owner := range apiKeys {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(key)) == 1 {
+			name, valid = owner, true
+		}
+	}
+	return name, valid
+}
+
+// domainParam returns the domain named by the "domain" query parameter,
+// falling back to the default domain
+func domainParam(r *http.Request) (string, bool) {
+	requested := r.URL.Query().Get("domain")
+	if requested == "" {
+		return domains[0], true
+	}
+	return lookupDomain(requested)
+}
+
+// createLinkRequest is the body of POST /api/links and one entry of an import
+type createLinkRequest struct {
+	URL       string `json:"url"`
+	Domain    string `json:"domain,omitempty"`
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+// createLink validates req, stores the new link on behalf of actor and
+// notifies webhooks
+func createLink(actor string, req createLinkRequest) (Link, error) {
+	if req.URL == "" {
+		return Link{}, errors.New("URL parameter is missing")
+	}
+
+	domain := domains[0]
+	if req.Domain != "" {
+		var ok bool
+		if domain, ok = lookupDomain(req.Domain); !ok {
+			return Link{}, errors.New("Unknown domain")
+		}
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresIn != "" {
+		ttl, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || ttl <= 0 {
+			return Link{}, errors.New("Invalid expires_in duration")
+		}
+		t := time.Now().Add(ttl).UTC()
+		expiresAt = &t
+	}
+
+	link := links.Create(domain, req.URL, expiresAt)
+	audit.Record(actor, auditCreate, nil, &link)
+	webhooks.Emit(eventLinkCreated, link)
+	return link, nil
+}
+
+// linkResponse is a link as returned by the API
+type linkResponse struct {
+	Link
+	ShortURL string `json:"short_url"`
+}
+
+func newLinkResponse(link Link) linkResponse {
+	return linkResponse{Link: link, ShortURL: shortURL(link)}
+}
+
+// linkStats is the body returned by GET /api/links/{key}/stats
+type linkStats struct {
+	Domain      string     `json:"domain"`
+	Key         string     `json:"key"`
+	ShortURL    string     `json:"short_url"`
+	Clicks      int64      `json:"clicks"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+}
+
+func handleCreateLink(w http.ResponseWriter, r *http.Request) {
+	var req createLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+
+	link, err := createLink(actorFrom(r), req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, newLinkResponse(link))
+}
+
+// importResult reports the outcome of one entry of an import request
+type importResult struct {
+	URL   string        `json:"url"`
+	Link  *linkResponse `json:"link,omitempty"`
+	Error string        `json:"error,omitempty"`
+}
+
+func handleImportLinks(w http.ResponseWriter, r *http.Request) {
+	var reqs []createLinkRequest
+	if err := json.NewDecoder(r.Body).Decode(&reqs); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(reqs) > maxImportLinks {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("at most %d links can be imported at once", maxImportLinks))
+		return
+	}
+
+	// Entries fail individually so one bad URL does not sink the batch
+	results := make([]importResult, len(reqs))
+	for i, req := range reqs {
+		results[i].URL = req.URL
+		link, err := createLink(actorFrom(r), req)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		resp := newLinkResponse(link)
+		results[i].Link = &resp
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func handleListLinks(w http.ResponseWriter, r *http.Request) {
+	domain := r.URL.Query().Get("domain")
+	if domain != "" {
+		var ok bool
+		if domain, ok = lookupDomain(domain); !ok {
+			writeError(w, http.StatusBadRequest, "Unknown domain")
+			return
+		}
+	}
+
+	result := []linkResponse{}
+	for _, link := range links.List(domain) {
+		result = append(result, newLinkResponse(link))
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+func handleGetLink(w http.ResponseWriter, r *http.Request) {
+	link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, newLinkResponse(link))
+}
+
+func handleLinkStats(w http.ResponseWriter, r *http.Request) {
+	link, ok := lookupLink(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, linkStats{
+		Domain:      link.Domain,
+		Key:         link.Key,
+		ShortURL:    shortURL(link),
+		Clicks:      link.Clicks,
+		CreatedAt:   link.CreatedAt,
+		LastClickAt: link.LastClickAt,
+		ExpiresAt:   link.ExpiresAt,
+	})
+}
+
+// lookupLink finds the link named by the request path and domain parameter,
+// writing an error response when there is none
+func lookupLink(w http.ResponseWriter, r *http.Request) (Link, bool) {
+	domain, ok := domainParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown domain")
+		return Link{}, false
+	}
+
+	link, found := links.Get(linkID{Domain: domain, Key: r.PathValue("key")})
+	if !found {
+		writeError(w, http.StatusNotFound, "Shortened key not found")
+		return Link{}, false
+	}
+	return link, true
+}
+
+func handleUpdateLink(w http.ResponseWriter, r *http.Request) {
+	domain, ok := domainParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown domain")
+		return
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, "URL parameter is missing")
+		return
+	}
+
+	before, link, found := links.Update(linkID{Domain: domain, Key: r.PathValue("key")}, func(link *Link) {
+		link.URL = req.URL
+	})
+	if !found {
+		writeError(w, http.StatusNotFound, "Shortened key not found")
+		return
+	}
+	audit.Record(actorFrom(r), auditUpdate, &before, &link)
+	writeJSON(w, http.StatusOK, newLinkResponse(link))
+}
+
+func handleDisableLink(w http.ResponseWriter, r *http.Request) {
+	setLinkDisabled(w, r, true)
+}
+
+func handleEnableLink(w http.ResponseWriter, r *http.Request) {
+	setLinkDisabled(w, r, false)
+}
+
+// setLinkDisabled switches redirects for the requested link off or on
+func setLinkDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	domain, ok := domainParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown domain")
+		return
+	}
+
+	before, link, found := links.Update(linkID{Domain: domain, Key: r.PathValue("key")}, func(link *Link) {
+		link.Disabled = disabled
+	})
+	if !found {
+		writeError(w, http.StatusNotFound, "Shortened key not found")
+		return
+	}
+
+	action := auditEnable
+	if disabled {
+		action = auditDisable
+	}
+	audit.Record(actorFrom(r), action, &before, &link)
+	writeJSON(w, http.StatusOK, newLinkResponse(link))
+}
+
+func handleDeleteLink(w http.ResponseWriter, r *http.Request) {
+	domain, ok := domainParam(r)
+	if !ok {
+		writeError(w, http.StatusBadRequest, "Unknown domain")
+		return
+	}
+
+	link, found := links.Delete(linkID{Domain: domain, Key: r.PathValue("key")})
+	if !found {
+		writeError(w, http.StatusNotFound, "Shortened key not found")
+		return
+	}
+
+	audit.Record(actorFrom(r), auditDelete, &link, nil)
+	webhooks.Emit(eventLinkDeleted, link)
+	writeJSON(w, http.StatusOK, newLinkResponse(link))
+}
+
+func handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, webhooks.Deliveries(r.URL.Query().Get("status")))
+}
+
+func handleResendDelivery(w http.ResponseWriter, r *http.Request) {
+	delivery, err := webhooks.Resend(r.PathValue("id"))
+	if errors.Is(err, errDeliveryNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, delivery)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
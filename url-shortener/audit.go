@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	auditCreate  = "create"
+	auditUpdate  = "update"
+	auditDelete  = "delete"
+	auditDisable = "disable"
+	auditEnable  = "enable"
+	auditExpire  = "expire"
+)
+
+// actorSystem is the actor for changes the server makes on its own
+const actorSystem = "system"
+
+// auditEvent is one entry of the audit trail. Before is nil for creations
+// and After is nil for removals.
+type auditEvent struct {
+	ID     string    `json:"id"`
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Action string    `json:"action"`
+	Domain string    `json:"domain"`
+	Key    string    `json:"key"`
+	Before *Link     `json:"before,omitempty"`
+	After  *Link     `json:"after,omitempty"`
+}
+
+// auditLog is an append-only record of every change to a link. Events are
+// written to a JSON Lines file and kept in memory for queries.
+type auditLog struct {
+	mu     sync.Mutex
+	events []auditEvent
+	file   *os.File
+}
+
+// openAuditLog loads the existing trail at path (if any) and opens it for
+// appending. An empty path keeps the trail in memory only.
+func openAuditLog(path string) (*auditLog, error) {
+	a := &auditLog{}
+	if path == "" {
+		return a, nil
+	}
+
+	if file, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var event auditEvent
+			if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("parsing audit log %s: %w", path, err)
+			}
+			a.events = append(a.events, event)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	a.file = file
+	return a, nil
+}
+
+// Record appends an event for a change made by actor. Pass nil for before
+// or after when the link did not exist on that side of the change.
+func (a *auditLog) Record(actor, action string, before, after *Link) {
+	event := auditEvent{
+		ID:     newID("aud"),
+		Time:   time.Now().UTC(),
+		Actor:  actor,
+		Action: action,
+		Before: before,
+		After:  after,
+	}
+	if after != nil {
+		event.Domain, event.Key = after.Domain, after.Key
+	} else if before != nil {
+		event.Domain, event.Key = before.Domain, before.Key
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events = append(a.events, event)
+	if a.file == nil {
+		return
+	}
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Error encoding audit event: %v\n", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Error writing audit log: %v\n", err)
+		return
+	}
+	// Compliance wants the record on disk before we carry on
+	if err := a.file.Sync(); err != nil {
+		fmt.Printf("Error syncing audit log: %v\n", err)
+	}
+}
+
+// auditFilter selects audit events. Zero fields match everything.
+type auditFilter struct {
+	Actor  string
+	Action string
+	Domain string
+	Key    string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func (f auditFilter) matches(event auditEvent) bool {
+	return (f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Action == "" || event.Action == f.Action) &&
+		(f.Domain == "" || event.Domain == f.Domain) &&
+		(f.Key == "" || event.Key == f.Key) &&
+		(f.Since.IsZero() || !event.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || event.Time.Before(f.Until))
+}
+
+// Query returns the events matching filter, oldest first. With a limit only
+// the most recent events are kept.
+func (a *auditLog) Query(filter auditFilter) []auditEvent {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	result := []auditEvent{}
+	for _, event := range a.events {
+		if filter.matches(event) {
+			result = append(result, event)
+		}
+	}
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[len(result)-filter.Limit:]
+	}
+	return result
+}
+
+// parseAuditFilter reads a filter from the query string of r
+func parseAuditFilter(r *http.Request) (auditFilter, error) {
+	query := r.URL.Query()
+	filter := auditFilter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Domain: query.Get("domain"),
+		Key:    query.Get("key"),
+	}
+
+	var err error
+	if since := query.Get("since"); since != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			return filter, fmt.Errorf("invalid since: %w", err)
+		}
+	}
+	if until := query.Get("until"); until != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			return filter, fmt.Errorf("invalid until: %w", err)
+		}
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	return filter, nil
+}
+
+func handleQueryAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, audit.Query(filter))
+}
+
+// handleExportAudit streams the matching events as JSON Lines
+func handleExportAudit(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+	for _, event := range audit.Query(filter) {
+		if err := enc.Encode(event); err != nil {
+			return
+		}
+	}
+}
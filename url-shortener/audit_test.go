@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeAuditLog writes events to a JSON Lines file the way auditLog does
+// and returns its path
+func writeAuditLog(t *testing.T, events []auditEvent) string {
+	t.Helper()
+	var b strings.Builder
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := os.WriteFile(path, []byte(b.String()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestAuditQueryFilters(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := writeAuditLog(t, []auditEvent{
+		{ID: "1", Time: start, Actor: "alice", Action: auditCreate, Domain: "a.example", Key: "x"},
+		{ID: "2", Time: start.Add(time.Hour), Actor: "bob", Action: auditUpdate, Domain: "a.example", Key: "x"},
+		{ID: "3", Time: start.Add(2 * time.Hour), Actor: "alice", Action: auditCreate, Domain: "b.example", Key: "y"},
+		{ID: "4", Time: start.Add(3 * time.Hour), Actor: actorSystem, Action: auditExpire, Domain: "a.example", Key: "x"},
+		{ID: "5", Time: start.Add(4 * time.Hour), Actor: "bob", Action: auditDelete, Domain: "b.example", Key: "y"},
+	})
+	trail, err := openAuditLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trail.file.Close()
+
+	tests := []struct {
+		query string
+		want  string // IDs of the events returned, in order
+	}{
+		{"", "1 2 3 4 5"},
+		{"actor=alice", "1 3"},
+		{"action=create", "1 3"},
+		{"domain=b.example", "3 5"},
+		{"key=x", "1 2 4"},
+		{"since=2024-05-01T14:00:00Z", "3 4 5"},
+		{"until=2024-05-01T14:00:00Z", "1 2"},
+		{"limit=2", "4 5"},
+		{"actor=bob&action=delete", "5"},
+		{"domain=a.example&key=x&actor=system", "4"},
+		{"since=2024-05-01T13:00:00Z&until=2024-05-01T15:00:00Z&domain=a.example", "2"},
+		{"domain=a.example&limit=1", "4"},
+		{"actor=carol", ""},
+		{"key=x&domain=b.example", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			filter, err := parseAuditFilter(httptest.NewRequest("GET", "/api/audit?"+tt.query, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, event := range trail.Query(filter) {
+				ids = append(ids, event.ID)
+			}
+			if got := strings.Join(ids, " "); got != tt.want {
+				t.Errorf("got events %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAuditFilterRejectsBadValues(t *testing.T) {
+	for _, query := range []string{"since=yesterday", "until=2024-05-01", "limit=-1", "limit=many"} {
+		if _, err := parseAuditFilter(httptest.NewRequest("GET", "/api/audit?"+query, nil)); err == nil {
+			t.Errorf("%s was accepted", query)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// linkLookup resolves a link by domain and key. Both linkStore and
+// redirectCache satisfy it so handleRedirect does not care which it gets.
+type linkLookup interface {
+	Get(id linkID) (Link, bool)
+}
+
+// redirectCache is a bounded LRU cache in front of a linkLookup. It also
+// remembers keys that were not found so that repeated misses do not reach
+// the backend; those entries expire after negativeTTL. Clicks change on
+// every redirect without invalidating the cache, so the links it returns
+// carry no click counts; read those from the store.
+type redirectCache struct {
+	backend     linkLookup
+	capacity    int
+	negativeTTL time.Duration
+
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	items map[linkID]*list.Element
+	// generation is bumped on every invalidation so that a lookup racing
+	// with an update does not put a stale value back into the cache
+	generation uint64
+
+	hits         uint64
+	negativeHits uint64
+	misses       uint64
+	evictions    uint64
+}
+
+type cacheEntry struct {
+	id       linkID
+	link     Link
+	found    bool
+	cachedAt time.Time
+}
+
+// cacheStats is a snapshot of the cache counters
+type cacheStats struct {
+	Capacity     int     `json:"capacity"`
+	Size         int     `json:"size"`
+	Hits         uint64  `json:"hits"`
+	NegativeHits uint64  `json:"negative_hits"`
+	Misses       uint64  `json:"misses"`
+	Evictions    uint64  `json:"evictions"`
+	HitRate      float64 `json:"hit_rate"`
+}
+
+func newRedirectCache(backend linkLookup, capacity int, negativeTTL time.Duration) *redirectCache {
+	return &redirectCache{
+		backend:     backend,
+		capacity:    capacity,
+		negativeTTL: negativeTTL,
+		order:       list.New(),
+		items:       make(map[linkID]*list.Element),
+	}
+}
+
+// Get returns the link for id from the cache, falling back to the backend
+// and caching whatever it answers
+func (c *redirectCache) Get(id linkID) (Link, bool) {
+	c.mu.Lock()
+	if elem, ok := c.items[id]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.found || time.Since(entry.cachedAt) < c.negativeTTL {
+			c.order.MoveToFront(elem)
+			if entry.found {
+				c.hits++
+			} else {
+				c.negativeHits++
+			}
+			c.mu.Unlock()
+			return entry.link, entry.found
+		}
+		c.remove(elem)
+	}
+	c.misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	link, found := c.backend.Get(id)
+	link.Clicks, link.LastClickAt = 0, nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.add(&cacheEntry{id: id, link: link, found: found, cachedAt: time.Now()})
+	}
+	return link, found
+}
+
+// Invalidate drops any cached answer for id. It must be called whenever a
+// link is created, updated or removed.
+func (c *redirectCache) Invalidate(id linkID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.items[id]; ok {
+		c.remove(elem)
+	}
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *redirectCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := cacheStats{
+		Capacity:     c.capacity,
+		Size:         c.order.Len(),
+		Hits:         c.hits,
+		NegativeHits: c.negativeHits,
+		Misses:       c.misses,
+		Evictions:    c.evictions,
+	}
+	if total := c.hits + c.negativeHits + c.misses; total > 0 {
+		stats.HitRate = float64(c.hits+c.negativeHits) / float64(total)
+	}
+	return stats
+}
+
+// add inserts entry, evicting the least recently used entries when full.
+// Callers hold c.mu.
+func (c *redirectCache) add(entry *cacheEntry) {
+	if elem, ok := c.items[entry.id]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[entry.id] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// remove drops elem from the cache. Callers hold c.mu.
+func (c *redirectCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*cacheEntry).id)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// countingBackend answers from links and counts the lookups that reach it.
+// duringGet, when set, runs in the middle of every lookup.
+type countingBackend struct {
+	links     map[linkID]Link
+	lookups   int
+	duringGet func()
+}
+
+func (b *countingBackend) Get(id linkID) (Link, bool) {
+	b.lookups++
+	link, found := b.links[id]
+	if b.duringGet != nil {
+		b.duringGet()
+	}
+	return link, found
+}
+
+func newCountingBackend(keys ...string) *countingBackend {
+	b := &countingBackend{links: make(map[linkID]Link)}
+	for _, key := range keys {
+		link := Link{Domain: "example.com", Key: key, URL: "https://example.com/" + key}
+		b.links[link.id()] = link
+	}
+	return b
+}
+
+func testID(key string) linkID {
+	return linkID{Domain: "example.com", Key: key}
+}
+
+func TestCacheInvalidatedOnUpdateAndDelete(t *testing.T) {
+	store := newLinkStore()
+	cache := newRedirectCache(store, 10, time.Minute)
+	store.onChange = cache.Invalidate
+
+	link := store.Create("example.com", "https://example.com/old", nil)
+	if got, found := cache.Get(link.id()); !found || got.URL != "https://example.com/old" {
+		t.Fatalf("Get = %v, %v", got, found)
+	}
+
+	store.Update(link.id(), func(l *Link) { l.URL = "https://example.com/new" })
+	if got, found := cache.Get(link.id()); !found || got.URL != "https://example.com/new" {
+		t.Errorf("after update Get = %v, %v, want the new URL", got, found)
+	}
+
+	store.Delete(link.id())
+	if got, found := cache.Get(link.id()); found {
+		t.Errorf("after delete Get = %v, want not found", got)
+	}
+}
+
+func TestCacheNegativeEntriesExpire(t *testing.T) {
+	const ttl = 20 * time.Millisecond
+	backend := newCountingBackend()
+	cache := newRedirectCache(backend, 10, ttl)
+
+	for range 3 {
+		if _, found := cache.Get(testID("missing")); found {
+			t.Fatal("found a key that does not exist")
+		}
+	}
+	if backend.lookups != 1 {
+		t.Errorf("%d lookups within the TTL, want 1", backend.lookups)
+	}
+
+	time.Sleep(2 * ttl)
+	cache.Get(testID("missing"))
+	if backend.lookups != 2 {
+		t.Errorf("%d lookups after the TTL, want 2", backend.lookups)
+	}
+	if stats := cache.Stats(); stats.NegativeHits != 2 || stats.Misses != 2 {
+		t.Errorf("stats = %+v, want 2 negative hits and 2 misses", stats)
+	}
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	backend := newCountingBackend("a", "b", "c")
+	cache := newRedirectCache(backend, 2, time.Minute)
+
+	cache.Get(testID("a"))
+	cache.Get(testID("b"))
+	cache.Get(testID("a")) // a is now more recent than b
+	cache.Get(testID("c")) // so b goes
+	if backend.lookups != 3 {
+		t.Fatalf("%d lookups, want 3", backend.lookups)
+	}
+
+	for _, tt := range []struct {
+		key     string
+		lookups int
+	}{
+		{"a", 3}, // still cached
+		{"c", 3}, // still cached
+		{"b", 4}, // evicted, and evicts a in turn
+		{"a", 5},
+	} {
+		cache.Get(testID(tt.key))
+		if backend.lookups != tt.lookups {
+			t.Errorf("after getting %s: %d lookups, want %d", tt.key, backend.lookups, tt.lookups)
+		}
+	}
+	if stats := cache.Stats(); stats.Evictions != 3 || stats.Size != 2 {
+		t.Errorf("stats = %+v, want 3 evictions and size 2", stats)
+	}
+}
+
+func TestCacheDropsRefillRacingInvalidation(t *testing.T) {
+	backend := newCountingBackend("a")
+	cache := newRedirectCache(backend, 10, time.Minute)
+
+	// The link changes while its old value is on the way back from the
+	// backend; that value must not be cached
+	backend.duringGet = func() {
+		backend.duringGet = nil
+		link := backend.links[testID("a")]
+		link.URL = "https://example.com/changed"
+		backend.links[testID("a")] = link
+		cache.Invalidate(testID("a"))
+	}
+	if got, _ := cache.Get(testID("a")); got.URL != "https://example.com/a" {
+		t.Fatalf("racing Get = %v, want the old URL", got)
+	}
+
+	if got, _ := cache.Get(testID("a")); got.URL != "https://example.com/changed" {
+		t.Errorf("Get = %v, want the changed URL", got)
+	}
+	if backend.lookups != 2 {
+		t.Errorf("%d lookups, want 2", backend.lookups)
+	}
+}
+
+func TestCacheLeavesOutClickCounts(t *testing.T) {
+	store := newLinkStore()
+	cache := newRedirectCache(store, 10, time.Minute)
+	store.onChange = cache.Invalidate
+
+	link := store.Create("example.com", "https://example.com/", nil)
+	store.RecordClick(link.id())
+	for range 2 { // a miss, then a hit
+		got, found := cache.Get(link.id())
+		if !found || got.Clicks != 0 || got.LastClickAt != nil {
+			t.Errorf("Get = %+v, %v, want the link without click counts", got, found)
+		}
+		store.RecordClick(link.id())
+	}
+	if got, _ := store.Get(link.id()); got.Clicks != 3 {
+		t.Errorf("store has %d clicks, want 3", got.Clicks)
+	}
+}
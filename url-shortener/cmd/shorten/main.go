@@ -0,0 +1,439 @@
+// Command shorten is a command-line client for the url-shortener JSON API.
+//
+// Usage:
+//
+//	shorten [-config file] [-o table|json] <command> [flags] [args]
+//
+// Commands are create, get, list, delete, stats and import. The server URL
+// and API key are read from a JSON config file, by default
+// $XDG_CONFIG_HOME/shorten/config.json:
+//
+//	{"server": "http://localhost:3030", "api_key": "...", "domain": "localhost:3030"}
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// config is the client configuration file
+type config struct {
+	Server string `json:"server"`
+	APIKey string `json:"api_key"`
+	Domain string `json:"domain"` // default domain for commands, optional
+}
+
+// link mirrors the server's link response
+type link struct {
+	Domain      string     `json:"domain"`
+	Key         string     `json:"key"`
+	URL         string     `json:"url"`
+	ShortURL    string     `json:"short_url"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Clicks      int64      `json:"clicks"`
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+}
+
+type createRequest struct {
+	URL       string `json:"url"`
+	Domain    string `json:"domain,omitempty"`
+	ExpiresIn string `json:"expires_in,omitempty"`
+}
+
+type importResult struct {
+	URL   string `json:"url"`
+	Link  *link  `json:"link,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// importBatchSize matches the server's limit on links per import request
+const importBatchSize = 1000
+
+func main() {
+	flag.Usage = usage
+	configPath := flag.String("config", defaultConfigPath(), "Path to the client config file")
+	output := flag.String("o", "table", "Output format: table or json")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *output != "table" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *output)
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		os.Exit(1)
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(cfg.Server, "/"),
+		apiKey: cfg.APIKey,
+		http:   &http.Client{Timeout: 30 * time.Second},
+	}
+	cli := &cli{client: c, domain: cfg.Domain, json: *output == "json", out: os.Stdout}
+
+	commands := map[string]func(args []string) error{
+		"create": cli.create,
+		"get":    cli.get,
+		"list":   cli.list,
+		"delete": cli.delete,
+		"stats":  cli.stats,
+		"import": cli.importLinks,
+	}
+
+	command, found := commands[flag.Arg(0)]
+	if !found {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	if err := command(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: shorten [-config file] [-o table|json] <command> [flags] [args]
+
+Commands:
+  create [-domain d] [-expires 24h] [url ...]   Shorten URLs (read from stdin when none are given)
+  get [-domain d] <key>                         Show a link
+  list [-domain d]                              List links
+  delete [-domain d] <key>                      Delete a link
+  stats [-domain d] <key>                       Show click statistics for a link
+  import [-domain d] [-expires 24h]             Shorten every URL read from stdin, one per line
+
+Global flags:
+`)
+	flag.PrintDefaults()
+}
+
+func defaultConfigPath() string {
+	if path := os.Getenv("SHORTEN_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "shorten.json"
+	}
+	return filepath.Join(dir, "shorten", "config.json")
+}
+
+func loadConfig(path string) (config, error) {
+	cfg := config{Server: "http://localhost:3030"}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if cfg.APIKey == "" {
+		return cfg, fmt.Errorf("%s has no api_key", path)
+	}
+	return cfg, nil
+}
+
+// client talks to the server's JSON API
+type client struct {
+	server string
+	apiKey string
+	http   *http.Client
+}
+
+// do sends a request with body encoded as JSON and decodes the response into
+// out. Error responses are turned into Go errors.
+func (c *client) do(method, path string, query url.Values, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	target := c.server + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, target, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return errors.New(resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// cli implements the subcommands on top of client
+type cli struct {
+	client *client
+	domain string
+	json   bool
+	out    io.Writer
+}
+
+// domainQuery returns the query selecting domain, if any
+func domainQuery(domain string) url.Values {
+	if domain == "" {
+		return nil
+	}
+	return url.Values{"domain": {domain}}
+}
+
+// parseKeyCommand parses the flags of a command that takes a single key
+func (c *cli) parseKeyCommand(name string, args []string) (key, domain string, err error) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&domain, "domain", c.domain, "Domain the key belongs to")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return "", "", fmt.Errorf("usage: shorten %s [-domain d] <key>", name)
+	}
+	return fs.Arg(0), domain, nil
+}
+
+func (c *cli) create(args []string) error {
+	fs := flag.NewFlagSet("create", flag.ExitOnError)
+	domain := fs.String("domain", c.domain, "Domain to create the links on")
+	expires := fs.String("expires", "", "Expire the links after this duration, e.g. 24h")
+	fs.Parse(args)
+
+	urls := fs.Args()
+	if len(urls) == 0 {
+		return c.importFrom(os.Stdin, *domain, *expires)
+	}
+
+	var created []link
+	for _, u := range urls {
+		var l link
+		req := createRequest{URL: u, Domain: *domain, ExpiresIn: *expires}
+		if err := c.client.do(http.MethodPost, "/api/links", nil, req, &l); err != nil {
+			return fmt.Errorf("creating %s: %w", u, err)
+		}
+		created = append(created, l)
+	}
+	return c.printLinks(created)
+}
+
+func (c *cli) get(args []string) error {
+	key, domain, err := c.parseKeyCommand("get", args)
+	if err != nil {
+		return err
+	}
+
+	var l link
+	if err := c.client.do(http.MethodGet, "/api/links/"+url.PathEscape(key), domainQuery(domain), nil, &l); err != nil {
+		return err
+	}
+	return c.printLinks([]link{l})
+}
+
+func (c *cli) list(args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	domain := fs.String("domain", c.domain, "Only list links on this domain")
+	fs.Parse(args)
+
+	var links []link
+	if err := c.client.do(http.MethodGet, "/api/links", domainQuery(*domain), nil, &links); err != nil {
+		return err
+	}
+	return c.printLinks(links)
+}
+
+func (c *cli) delete(args []string) error {
+	key, domain, err := c.parseKeyCommand("delete", args)
+	if err != nil {
+		return err
+	}
+
+	var l link
+	if err := c.client.do(http.MethodDelete, "/api/links/"+url.PathEscape(key), domainQuery(domain), nil, &l); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(l)
+	}
+	fmt.Fprintf(c.out, "Deleted %s\n", l.ShortURL)
+	return nil
+}
+
+func (c *cli) stats(args []string) error {
+	key, domain, err := c.parseKeyCommand("stats", args)
+	if err != nil {
+		return err
+	}
+
+	var s link
+	if err := c.client.do(http.MethodGet, "/api/links/"+url.PathEscape(key)+"/stats", domainQuery(domain), nil, &s); err != nil {
+		return err
+	}
+	if c.json {
+		return c.printJSON(s)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHORT URL\tCLICKS\tLAST CLICK\tCREATED\tEXPIRES")
+	fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n",
+		s.ShortURL, s.Clicks, formatTime(s.LastClickAt), formatTime(&s.CreatedAt), formatTime(s.ExpiresAt))
+	return tw.Flush()
+}
+
+func (c *cli) importLinks(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	domain := fs.String("domain", c.domain, "Domain to create the links on")
+	expires := fs.String("expires", "", "Expire the links after this duration, e.g. 24h")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		return errors.New("usage: shorten import [-domain d] [-expires 24h] < urls.txt")
+	}
+	return c.importFrom(os.Stdin, *domain, *expires)
+}
+
+// importFrom shortens every non-empty line of r in batches. Lines that the
+// server rejects are reported but do not stop the import.
+func (c *cli) importFrom(r io.Reader, domain, expires string) error {
+	var results []importResult
+	var batch []createRequest
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		var batchResults []importResult
+		if err := c.client.do(http.MethodPost, "/api/links/import", nil, batch, &batchResults); err != nil {
+			return err
+		}
+		results = append(results, batchResults...)
+		batch = batch[:0]
+		return nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		batch = append(batch, createRequest{URL: line, Domain: domain, ExpiresIn: expires})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	if c.json {
+		if err := c.printJSON(results); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "URL\tSHORT URL\tERROR")
+		for _, result := range results {
+			shortURL := ""
+			if result.Link != nil {
+				shortURL = result.Link.ShortURL
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", result.URL, shortURL, result.Error)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d URLs could not be imported", failed, len(results))
+	}
+	return nil
+}
+
+func (c *cli) printLinks(links []link) error {
+	if c.json {
+		if links == nil {
+			links = []link{}
+		}
+		return c.printJSON(links)
+	}
+
+	tw := tabwriter.NewWriter(c.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SHORT URL\tURL\tCLICKS\tCREATED\tEXPIRES")
+	for _, l := range links {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			l.ShortURL, l.URL, strconv.FormatInt(l.Clicks, 10), formatTime(&l.CreatedAt), formatTime(l.ExpiresAt))
+	}
+	return tw.Flush()
+}
+
+func (c *cli) printJSON(v any) error {
+	enc := json.NewEncoder(c.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04")
+}
@@ -1,23 +1,129 @@
 package main
 
 import (
+	"expvar"
+	"flag"
 	"fmt"
+	"html"
 	"math/rand"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
 
-var urls = make(map[string]string)
+var (
+    links      = newLinkStore()
+    webhooks   *webhookDispatcher
+    audit      *auditLog
+
+    // apiKeys maps each API key to the name of its owner
+    apiKeys = make(map[string]string)
+    // admins holds the users allowed to use the admin API
+    admins = make(map[string]bool)
+
+    // redirects answers lookups in handleRedirect, either links itself or
+    // a cache in front of it
+    redirects linkLookup = links
+
+    // domains lists the short domains links can be created on, the first
+    // one being the default
+    domains []string
+    scheme  string
+)
 
 func main() {
+    webhookConfigPath := flag.String("webhooks", "", "Path to a JSON file describing outbound webhooks")
+    webhookLogPath := flag.String("webhook-log", "webhook-deliveries.jsonl", "Path of the persistent webhook delivery log")
+    webhookKeep := flag.Int("webhook-keep", 1000, "Number of finished webhook deliveries kept for listing and resending")
+    domainList := flag.String("domains", "localhost:3030", "Comma-separated list of short domains, the first is the default")
+    flag.StringVar(&scheme, "scheme", "http", "Scheme used when building shortened URLs")
+    cacheSize := flag.Int("cache-size", 10000, "Number of redirect lookups to keep in the LRU cache (0 disables it)")
+    negativeTTL := flag.Duration("cache-negative-ttl", 30*time.Second, "How long unknown keys stay cached")
+    adminToken := flag.String("admin-token", os.Getenv("ADMIN_TOKEN"), "API key granted to the \"admin\" user")
+    apiKeysPath := flag.String("api-keys", "", "Path to a JSON object mapping user names to API keys")
+    adminList := flag.String("admins", "admin", "Comma-separated users allowed to use the admin API")
+    auditLogPath := flag.String("audit-log", "audit.jsonl", "Path of the append-only audit log")
+    flag.Parse()
+
+    domains = parseDomains(*domainList)
+    if len(domains) == 0 {
+        fmt.Println("At least one domain is required")
+        os.Exit(1)
+    }
+
+    if *apiKeysPath != "" {
+        if err := loadAPIKeys(*apiKeysPath); err != nil {
+            fmt.Printf("Error loading API keys: %v\n", err)
+            os.Exit(1)
+        }
+    }
+    if *adminToken != "" {
+        apiKeys[*adminToken] = "admin"
+    }
+    for _, name := range strings.Split(*adminList, ",") {
+        if name = strings.TrimSpace(name); name != "" {
+            admins[name] = true
+        }
+    }
+
     // Initialize random seed
     rand.Seed(time.Now().UnixNano())
 
+    var hooks []webhookConfig
+    var err error
+    if *webhookConfigPath != "" {
+        hooks, err = loadWebhookConfig(*webhookConfigPath)
+        if err != nil {
+            fmt.Printf("Error loading webhooks: %v\n", err)
+            os.Exit(1)
+        }
+    }
+
+    webhooks, err = newWebhookDispatcher(hooks, *webhookLogPath, *webhookKeep)
+    if err != nil {
+        fmt.Printf("Error opening webhook delivery log: %v\n", err)
+        os.Exit(1)
+    }
+
+    audit, err = openAuditLog(*auditLogPath)
+    if err != nil {
+        fmt.Printf("Error opening audit log: %v\n", err)
+        os.Exit(1)
+    }
+
+    if *cacheSize > 0 {
+        cache := newRedirectCache(links, *cacheSize, *negativeTTL)
+        links.onChange = cache.Invalidate
+        redirects = cache
+        // Served with the other expvars on /debug/vars
+        expvar.Publish("redirect_cache", expvar.Func(func() any { return cache.Stats() }))
+    }
+
+    go expireLinks(time.Minute)
+
     http.HandleFunc("/", handleForm)
     http.HandleFunc("/shorten", handleShorten)
     http.HandleFunc("/short/", handleRedirect)
 
+    // JSON API, used by the shorten CLI
+    http.HandleFunc("POST /api/links", requireAPIKey(handleCreateLink))
+    http.HandleFunc("POST /api/links/import", requireAPIKey(handleImportLinks))
+    http.HandleFunc("GET /api/links", requireAPIKey(handleListLinks))
+    http.HandleFunc("GET /api/links/{key}", requireAPIKey(handleGetLink))
+    http.HandleFunc("GET /api/links/{key}/stats", requireAPIKey(handleLinkStats))
+    http.HandleFunc("PATCH /api/links/{key}", requireAPIKey(handleUpdateLink))
+    http.HandleFunc("POST /api/links/{key}/disable", requireAPIKey(handleDisableLink))
+    http.HandleFunc("POST /api/links/{key}/enable", requireAPIKey(handleEnableLink))
+    http.HandleFunc("DELETE /api/links/{key}", requireAPIKey(handleDeleteLink))
+
+    // Admin API
+    http.HandleFunc("GET /api/webhooks/deliveries", requireAdmin(handleListDeliveries))
+    http.HandleFunc("POST /api/webhooks/deliveries/{id}/resend", requireAdmin(handleResendDelivery))
+    http.HandleFunc("GET /api/audit", requireAdmin(handleQueryAudit))
+    http.HandleFunc("GET /api/audit/export", requireAdmin(handleExportAudit))
+
     fmt.Println("URL Shortener is running on :3030")
     http.ListenAndServe(":3030", nil)
 }
@@ -58,7 +164,7 @@ func handleForm(w http.ResponseWriter, r *http.Request) {
                     flex-direction: column;
                     gap: 15px;
                 }
-                input[type="url"] {
+                input[type="url"], select {
                     padding: 12px;
                     border: 1px solid #ddd;
                     border-radius: 4px;
@@ -83,6 +189,13 @@ func handleForm(w http.ResponseWriter, r *http.Request) {
                 <h2>URL Shortener</h2>
                 <form method="post" action="/shorten">
                     <input type="url" name="url" placeholder="Enter a URL" required>
+                    `, domainSelect(), `
+                    <select name="expires_in">
+                        <option value="">Never expires</option>
+                        <option value="1h">Expires in 1 hour</option>
+                        <option value="24h">Expires in 1 day</option>
+                        <option value="168h">Expires in 1 week</option>
+                    </select>
                     <input type="submit" value="Shorten">
                 </form>
             </div>
@@ -97,15 +210,18 @@ func handleShorten(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    originalURL := r.FormValue("url")
-    if originalURL == "" {
-        http.Error(w, "URL parameter is missing", http.StatusBadRequest)
+    link, err := createLink(actorFrom(r), createLinkRequest{
+        URL:       r.FormValue("url"),
+        Domain:    r.FormValue("domain"),
+        ExpiresIn: r.FormValue("expires_in"),
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
         return
     }
 
-    shortKey := generateShortKey()
-    urls[shortKey] = originalURL
-    shortenedURL := fmt.Sprintf("http://localhost:3030/short/%s", shortKey)
+    originalURL := link.URL
+    shortenedURL := shortURL(link)
 
     w.Header().Set("Content-Type", "text/html")
     fmt.Fprint(w, `
@@ -184,13 +300,102 @@ func handleRedirect(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    originalURL, found := urls[shortKey]
+    link, found := redirects.Get(linkID{Domain: requestDomain(r), Key: shortKey})
     if !found {
         http.Error(w, "Shortened key not found", http.StatusNotFound)
         return
     }
 
-    http.Redirect(w, r, originalURL, http.StatusMovedPermanently)
+    if link.Disabled {
+        http.Error(w, "Shortened key has been disabled", http.StatusGone)
+        return
+    }
+
+    if link.Expired(time.Now()) {
+        // Only whoever actually removes the link reports the expiry
+        if expired, removed := links.Delete(link.id()); removed {
+            audit.Record(actorSystem, auditExpire, &expired, nil)
+            webhooks.Emit(eventLinkExpired, expired)
+        }
+        http.Error(w, "Shortened key has expired", http.StatusGone)
+        return
+    }
+
+    if clicked, ok := links.RecordClick(link.id()); ok {
+        webhooks.ClickRecorded(clicked)
+    }
+
+    // A permanent redirect would be cached by browsers and proxies, which
+    // would then miss later updates and stop counting clicks
+    http.Redirect(w, r, link.URL, http.StatusFound)
+}
+
+// parseDomains splits a comma-separated list of domains and lowercases them
+func parseDomains(list string) []string {
+    var result []string
+    for _, domain := range strings.Split(list, ",") {
+        domain = strings.ToLower(strings.TrimSpace(domain))
+        if domain != "" {
+            result = append(result, domain)
+        }
+    }
+    return result
+}
+
+// lookupDomain returns the configured domain matching name, ignoring case
+func lookupDomain(name string) (string, bool) {
+    name = strings.ToLower(name)
+    for _, domain := range domains {
+        if domain == name {
+            return domain, true
+        }
+    }
+    return "", false
+}
+
+// requestDomain maps the Host header of r onto a configured domain. Hosts
+// that are not configured are returned as-is so that lookups simply miss.
+func requestDomain(r *http.Request) string {
+    if domain, ok := lookupDomain(r.Host); ok {
+        return domain
+    }
+    if host, _, err := net.SplitHostPort(r.Host); err == nil {
+        if domain, ok := lookupDomain(host); ok {
+            return domain
+        }
+    }
+    return strings.ToLower(r.Host)
+}
+
+// shortURL returns the public URL of link on its domain
+func shortURL(link Link) string {
+    return fmt.Sprintf("%s://%s/short/%s", scheme, link.Domain, link.Key)
+}
+
+// domainSelect renders a domain picker for the form, or nothing when there
+// is only one domain to choose from
+func domainSelect() string {
+    if len(domains) < 2 {
+        return ""
+    }
+
+    var b strings.Builder
+    b.WriteString(`<select name="domain">`)
+    for _, domain := range domains {
+        fmt.Fprintf(&b, `<option value="%[1]s">%[1]s</option>`, html.EscapeString(domain))
+    }
+    b.WriteString(`</select>`)
+    return b.String()
+}
+
+// expireLinks periodically removes expired links and notifies webhooks
+func expireLinks(interval time.Duration) {
+    for range time.Tick(interval) {
+        for _, link := range links.RemoveExpired(time.Now()) {
+            audit.Record(actorSystem, auditExpire, &link, nil)
+            webhooks.Emit(eventLinkExpired, link)
+        }
+    }
 }
 
 func generateShortKey() string {
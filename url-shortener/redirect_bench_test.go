@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// backendLatency approximates a round trip to a persistent store
+const backendLatency = 200 * time.Microsecond
+
+// slowBackend wraps a linkStore and delays every lookup by latency
+type slowBackend struct {
+	store   *linkStore
+	latency time.Duration
+}
+
+func (b slowBackend) Get(id linkID) (Link, bool) {
+	time.Sleep(b.latency)
+	return b.store.Get(id)
+}
+
+// setupRedirects fills the global store with n links and points redirects
+// at lookup, returning the keys that were created
+func setupRedirects(b *testing.B, n int, lookup func(backend linkLookup) linkLookup) []string {
+	b.Helper()
+
+	domains = []string{"localhost:3030"}
+	scheme = "http"
+	links = newLinkStore()
+	var err error
+	if webhooks, err = newWebhookDispatcher(nil, "", 0); err != nil {
+		b.Fatal(err)
+	}
+	if audit, err = openAuditLog(""); err != nil {
+		b.Fatal(err)
+	}
+
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = links.Create(domains[0], fmt.Sprintf("https://example.com/%d", i), nil).Key
+	}
+
+	redirects = lookup(slowBackend{store: links, latency: backendLatency})
+	if cache, ok := redirects.(*redirectCache); ok {
+		links.onChange = cache.Invalidate
+	}
+	return keys
+}
+
+func benchmarkRedirect(b *testing.B, keys []string, wantStatus int) {
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/short/"+keys[i%len(keys)], nil)
+		req.Host = domains[0]
+		rec := httptest.NewRecorder()
+		handleRedirect(rec, req)
+		if rec.Code != wantStatus {
+			b.Fatalf("got status %d, want %d", rec.Code, wantStatus)
+		}
+	}
+	b.StopTimer()
+
+	if cache, ok := redirects.(*redirectCache); ok {
+		b.ReportMetric(cache.Stats().HitRate, "hit-rate")
+	}
+}
+
+func noCache(backend linkLookup) linkLookup { return backend }
+
+func withCache(size int) func(backend linkLookup) linkLookup {
+	return func(backend linkLookup) linkLookup {
+		return newRedirectCache(backend, size, time.Minute)
+	}
+}
+
+func BenchmarkRedirect(b *testing.B) {
+	b.Run("no-cache", func(b *testing.B) {
+		keys := setupRedirects(b, 1000, noCache)
+		benchmarkRedirect(b, keys, http.StatusFound)
+	})
+
+	b.Run("cache", func(b *testing.B) {
+		keys := setupRedirects(b, 1000, withCache(1000))
+		benchmarkRedirect(b, keys, http.StatusFound)
+	})
+
+	// Cycling through twice as many keys as the cache holds is the worst
+	// case for an LRU: every lookup misses and pays for an eviction
+	b.Run("cache-undersized", func(b *testing.B) {
+		keys := setupRedirects(b, 1000, withCache(500))
+		benchmarkRedirect(b, keys, http.StatusFound)
+	})
+}
+
+func BenchmarkRedirectNotFound(b *testing.B) {
+	missing := []string{"nokey1", "nokey2", "nokey3"}
+
+	b.Run("no-cache", func(b *testing.B) {
+		setupRedirects(b, 10, noCache)
+		benchmarkRedirect(b, missing, http.StatusNotFound)
+	})
+
+	b.Run("cache", func(b *testing.B) {
+		setupRedirects(b, 10, withCache(1000))
+		benchmarkRedirect(b, missing, http.StatusNotFound)
+	})
+}
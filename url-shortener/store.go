@@ -0,0 +1,172 @@
+package main
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// Link is a shortened URL together with its bookkeeping
+type Link struct {
+	Domain    string     `json:"domain"`
+	Key       string     `json:"key"`
+	URL       string     `json:"url"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Clicks    int64      `json:"clicks"`
+	Disabled  bool       `json:"disabled,omitempty"`
+
+	LastClickAt *time.Time `json:"last_click_at,omitempty"`
+}
+
+// Expired reports whether the link has an expiry that is at or before now
+func (l Link) Expired(now time.Time) bool {
+	return l.ExpiresAt != nil && !now.Before(*l.ExpiresAt)
+}
+
+// linkID identifies a link. Keys only need to be unique within a domain.
+type linkID struct {
+	Domain string
+	Key    string
+}
+
+func (l Link) id() linkID {
+	return linkID{Domain: l.Domain, Key: l.Key}
+}
+
+// linkStore holds all links in memory, keyed by domain and short key
+type linkStore struct {
+	mu    sync.RWMutex
+	links map[linkID]*Link
+
+	// onChange, when set, is called with the id of every link that is
+	// created, updated or removed while the store is still locked
+	onChange func(id linkID)
+}
+
+func newLinkStore() *linkStore {
+	return &linkStore{links: make(map[linkID]*Link)}
+}
+
+// Create stores originalURL under a fresh short key on domain and returns
+// the new link
+func (s *linkStore) Create(domain, originalURL string, expiresAt *time.Time) Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Keep generating until we hit a key that is not taken on this domain
+	id := linkID{Domain: domain, Key: generateShortKey()}
+	for s.links[id] != nil {
+		id.Key = generateShortKey()
+	}
+
+	link := &Link{
+		Domain:    id.Domain,
+		Key:       id.Key,
+		URL:       originalURL,
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expiresAt,
+	}
+	s.links[id] = link
+	s.changed(id)
+	return *link
+}
+
+// Get returns a copy of the link stored under id
+func (s *linkStore) Get(id linkID) (Link, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, found := s.links[id]
+	if !found {
+		return Link{}, false
+	}
+	return *link, true
+}
+
+// List returns copies of all links on domain, or on every domain when domain
+// is empty, ordered by creation time
+func (s *linkStore) List(domain string) []Link {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var result []Link
+	for id, link := range s.links {
+		if domain == "" || id.Domain == domain {
+			result = append(result, *link)
+		}
+	}
+	slices.SortFunc(result, func(a, b Link) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return result
+}
+
+// Delete removes the link stored under id and returns what was removed
+func (s *linkStore) Delete(id linkID) (Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, found := s.links[id]
+	if !found {
+		return Link{}, false
+	}
+	delete(s.links, id)
+	s.changed(id)
+	return *link, true
+}
+
+// Update applies update to the link stored under id and returns the link as
+// it was before and after the change
+func (s *linkStore) Update(id linkID, update func(link *Link)) (before, after Link, found bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, found := s.links[id]
+	if !found {
+		return Link{}, Link{}, false
+	}
+	before = *link
+	update(link)
+	// The domain and key are the identity of the link and cannot change
+	link.Domain, link.Key = id.Domain, id.Key
+	s.changed(id)
+	return before, *link, true
+}
+
+// RecordClick increments the click counter of id and returns the updated link
+func (s *linkStore) RecordClick(id linkID) (Link, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	link, found := s.links[id]
+	if !found {
+		return Link{}, false
+	}
+	link.Clicks++
+	now := time.Now().UTC()
+	link.LastClickAt = &now
+	return *link, true
+}
+
+// RemoveExpired deletes every link that has expired by now and returns them
+func (s *linkStore) RemoveExpired(now time.Time) []Link {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []Link
+	for id, link := range s.links {
+		if link.Expired(now) {
+			expired = append(expired, *link)
+			delete(s.links, id)
+			s.changed(id)
+		}
+	}
+	return expired
+}
+
+func (s *linkStore) changed(id linkID) {
+	if s.onChange != nil {
+		s.onChange(id)
+	}
+}
@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Events that can be delivered to webhooks
+const (
+	eventLinkCreated        = "link.created"
+	eventLinkDeleted        = "link.deleted"
+	eventLinkExpired        = "link.expired"
+	eventLinkClickThreshold = "link.click_threshold"
+)
+
+// Delivery states as written to the delivery log
+const (
+	deliveryPending   = "pending"
+	deliverySucceeded = "succeeded"
+	deliveryFailed    = "failed"
+)
+
+var errDeliveryNotFound = errors.New("delivery not found")
+
+// webhookConfig describes one outbound webhook as read from the config file
+type webhookConfig struct {
+	URL             string   `json:"url"`
+	Secret          string   `json:"secret"`
+	Events          []string `json:"events"` // empty means every event
+	ClickThresholds []int64  `json:"click_thresholds"`
+}
+
+// wants reports whether the webhook is subscribed to event
+func (c webhookConfig) wants(event string) bool {
+	return len(c.Events) == 0 || slices.Contains(c.Events, event)
+}
+
+// loadWebhookConfig reads a JSON array of webhooks from path
+func loadWebhookConfig(path string) ([]webhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var hooks []webhookConfig
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, hook := range hooks {
+		if hook.URL == "" {
+			return nil, fmt.Errorf("webhook %d in %s has no url", i, path)
+		}
+	}
+	return hooks, nil
+}
+
+// webhookEvent is the JSON body posted to a webhook
+type webhookEvent struct {
+	ID         string    `json:"id"`
+	Event      string    `json:"event"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Link       Link      `json:"link"`
+	Threshold  int64     `json:"threshold,omitempty"`
+}
+
+// webhookDelivery tracks sending one event to one webhook
+type webhookDelivery struct {
+	ID           string          `json:"id"`
+	WebhookURL   string          `json:"webhook_url"`
+	Event        string          `json:"event"`
+	Payload      json.RawMessage `json:"payload"`
+	Status       string          `json:"status"`
+	Attempts     int             `json:"attempts"`
+	ResponseCode int             `json:"response_code,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+// webhookDispatcher signs and sends events to the configured webhooks and
+// keeps a persistent log of every delivery. Only the latest maxKept
+// finished deliveries are kept; pending ones are never dropped.
+type webhookDispatcher struct {
+	hooks       []webhookConfig
+	client      *http.Client
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	maxKept     int
+
+	mu         sync.Mutex
+	deliveries map[string]*webhookDelivery
+	logFile    *os.File
+}
+
+// newWebhookDispatcher loads the existing delivery log at logPath (if any),
+// rewrites it with only the deliveries kept and opens it for appending.
+// maxKept is how many finished deliveries stay listed and can be resent.
+func newWebhookDispatcher(hooks []webhookConfig, logPath string, maxKept int) (*webhookDispatcher, error) {
+	d := &webhookDispatcher{
+		hooks:       hooks,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 5,
+		baseBackoff: time.Second,
+		maxBackoff:  time.Minute,
+		maxKept:     maxKept,
+		deliveries:  make(map[string]*webhookDelivery),
+	}
+	if logPath == "" {
+		return d, nil
+	}
+
+	if err := d.loadLog(logPath); err != nil {
+		return nil, err
+	}
+	d.prune()
+	if err := d.compactLog(logPath); err != nil {
+		return nil, err
+	}
+
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d.logFile = logFile
+	return d, nil
+}
+
+// loadLog replays the delivery log so earlier deliveries can be listed and
+// resent. The last record for each delivery wins.
+func (d *webhookDispatcher) loadLog(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var delivery webhookDelivery
+		if err := json.Unmarshal(scanner.Bytes(), &delivery); err != nil {
+			return fmt.Errorf("parsing delivery log %s: %w", path, err)
+		}
+		d.deliveries[delivery.ID] = &delivery
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// Anything still pending was cut short by a restart
+	for _, delivery := range d.deliveries {
+		if delivery.Status == deliveryPending {
+			delivery.Status = deliveryFailed
+			delivery.LastError = "interrupted by server restart"
+		}
+	}
+	return nil
+}
+
+// compactLog replaces the log at path with one record per delivery kept,
+// so that it does not keep growing across restarts
+func (d *webhookDispatcher) compactLog(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*webhookDelivery, 0, len(d.deliveries))
+	for _, delivery := range d.deliveries {
+		kept = append(kept, delivery)
+	}
+	slices.SortFunc(kept, func(a, b *webhookDelivery) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	partial := file.Name()
+	writer := bufio.NewWriter(file)
+	for _, delivery := range kept {
+		line, err := json.Marshal(delivery)
+		if err != nil {
+			file.Close()
+			os.Remove(partial)
+			return err
+		}
+		writer.Write(append(line, '\n'))
+	}
+	err = writer.Flush()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(partial, info.Mode().Perm())
+	}
+	if err == nil {
+		err = os.Rename(partial, path)
+	}
+	if err != nil {
+		os.Remove(partial)
+		return fmt.Errorf("compacting delivery log %s: %w", path, err)
+	}
+	return nil
+}
+
+// prune forgets the oldest finished deliveries beyond maxKept. Callers hold
+// d.mu.
+func (d *webhookDispatcher) prune() {
+	var finished []*webhookDelivery
+	for _, delivery := range d.deliveries {
+		if delivery.Status != deliveryPending {
+			finished = append(finished, delivery)
+		}
+	}
+	if len(finished) <= d.maxKept {
+		return
+	}
+	slices.SortFunc(finished, func(a, b *webhookDelivery) int {
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	})
+	for _, delivery := range finished[:len(finished)-d.maxKept] {
+		delete(d.deliveries, delivery.ID)
+	}
+}
+
+// Emit sends event for link to every webhook subscribed to it
+func (d *webhookDispatcher) Emit(event string, link Link) {
+	for _, hook := range d.hooks {
+		if hook.wants(event) {
+			d.enqueue(hook, webhookEvent{Event: event, Link: link})
+		}
+	}
+}
+
+// ClickRecorded notifies webhooks whose click threshold link has just reached
+func (d *webhookDispatcher) ClickRecorded(link Link) {
+	for _, hook := range d.hooks {
+		if hook.wants(eventLinkClickThreshold) && slices.Contains(hook.ClickThresholds, link.Clicks) {
+			d.enqueue(hook, webhookEvent{Event: eventLinkClickThreshold, Link: link, Threshold: link.Clicks})
+		}
+	}
+}
+
+func (d *webhookDispatcher) enqueue(hook webhookConfig, event webhookEvent) {
+	now := time.Now().UTC()
+	event.ID = newID("evt")
+	event.OccurredAt = now
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		fmt.Printf("Error encoding webhook event: %v\n", err)
+		return
+	}
+
+	delivery := &webhookDelivery{
+		ID:         newID("dlv"),
+		WebhookURL: hook.URL,
+		Event:      event.Event,
+		Payload:    payload,
+		Status:     deliveryPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	d.mu.Lock()
+	d.deliveries[delivery.ID] = delivery
+	d.record(delivery)
+	d.mu.Unlock()
+
+	go d.deliver(hook, delivery.ID)
+}
+
+// Resend queues a failed delivery again with a fresh set of attempts
+func (d *webhookDispatcher) Resend(id string) (webhookDelivery, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivery, found := d.deliveries[id]
+	if !found {
+		return webhookDelivery{}, errDeliveryNotFound
+	}
+	if delivery.Status != deliveryFailed {
+		return webhookDelivery{}, fmt.Errorf("delivery %s is %s, only failed deliveries can be resent", id, delivery.Status)
+	}
+
+	idx := slices.IndexFunc(d.hooks, func(h webhookConfig) bool { return h.URL == delivery.WebhookURL })
+	if idx < 0 {
+		return webhookDelivery{}, fmt.Errorf("webhook %s is no longer configured", delivery.WebhookURL)
+	}
+
+	delivery.Status = deliveryPending
+	delivery.Attempts = 0
+	delivery.ResponseCode = 0
+	delivery.LastError = ""
+	delivery.UpdatedAt = time.Now().UTC()
+	d.record(delivery)
+
+	go d.deliver(d.hooks[idx], delivery.ID)
+	return *delivery, nil
+}
+
+// Deliveries returns all known deliveries, optionally filtered by status,
+// oldest first
+func (d *webhookDispatcher) Deliveries(status string) []webhookDelivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var result []webhookDelivery
+	for _, delivery := range d.deliveries {
+		if status == "" || delivery.Status == status {
+			result = append(result, *delivery)
+		}
+	}
+	slices.SortFunc(result, func(a, b webhookDelivery) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	})
+	return result
+}
+
+// deliver posts the delivery's payload, retrying with exponential backoff
+// until it succeeds or maxAttempts is reached
+func (d *webhookDispatcher) deliver(hook webhookConfig, id string) {
+	d.mu.Lock()
+	payload := d.deliveries[id].Payload
+	d.mu.Unlock()
+
+	for attempt := 1; attempt <= d.maxAttempts; attempt++ {
+		code, err := d.send(hook, id, payload)
+
+		d.mu.Lock()
+		delivery := d.deliveries[id]
+		delivery.Attempts = attempt
+		delivery.ResponseCode = code
+		delivery.UpdatedAt = time.Now().UTC()
+		switch {
+		case err == nil:
+			delivery.Status = deliverySucceeded
+			delivery.LastError = ""
+		case attempt == d.maxAttempts:
+			delivery.Status = deliveryFailed
+			delivery.LastError = err.Error()
+		default:
+			delivery.LastError = err.Error()
+		}
+		d.record(delivery)
+		done := delivery.Status != deliveryPending
+		if done {
+			d.prune()
+		}
+		d.mu.Unlock()
+
+		if done {
+			if err != nil {
+				fmt.Printf("Webhook delivery %s to %s failed: %v\n", id, hook.URL, err)
+			}
+			return
+		}
+		time.Sleep(d.backoff(attempt))
+	}
+}
+
+// backoff returns the wait before the attempt following attempt
+func (d *webhookDispatcher) backoff(attempt int) time.Duration {
+	wait := d.baseBackoff << (attempt - 1)
+	if wait <= 0 || wait > d.maxBackoff {
+		wait = d.maxBackoff
+	}
+	return wait
+}
+
+// send makes a single signed POST to the webhook
+func (d *webhookDispatcher) send(hook webhookConfig, id string, payload []byte) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Shortener-Delivery", id)
+	req.Header.Set("X-Shortener-Timestamp", timestamp)
+	req.Header.Set("X-Shortener-Signature", "sha256="+signPayload(hook.Secret, timestamp, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// record appends the current state of delivery to the log. Callers hold d.mu.
+func (d *webhookDispatcher) record(delivery *webhookDelivery) {
+	if d.logFile == nil {
+		return
+	}
+
+	line, err := json.Marshal(delivery)
+	if err != nil {
+		fmt.Printf("Error encoding delivery %s: %v\n", delivery.ID, err)
+		return
+	}
+	if _, err := d.logFile.Write(append(line, '\n')); err != nil {
+		fmt.Printf("Error writing delivery log: %v\n", err)
+	}
+}
+
+// signPayload returns the hex HMAC-SHA256 of "timestamp.payload" keyed with
+// secret. Receivers recompute it to check that a request came from us.
+func signPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newID returns a random identifier with the given prefix
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return prefix + "_" + hex.EncodeToString(b)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignPayload(t *testing.T) {
+	// Expected values computed independently with Python's hmac module
+	tests := []struct {
+		name      string
+		secret    string
+		timestamp string
+		payload   string
+		want      string
+	}{
+		{"event", "s3cret", "1700000000", `{"id":"evt_1"}`, "8e6f5c9e12ccd802130a0cf337c2d90395d59605481627c32bb6c530172adf23"},
+		{"later timestamp", "s3cret", "1700000001", `{"id":"evt_1"}`, "7c309b409a4f2f7b9a5b858ff4ee389f856b18f0f406a2e5aef600eb019e4b89"},
+		{"no secret", "", "1700000000", `{}`, "a9dc44c8eda3de70e9cbf3e488895f1abc26acb1461d3124a3cb886af35251cf"},
+		{"empty payload", "other", "1700000000", "", "0eaddda63fe194e9945e7d364f142d9269b757e14bfcfc330d1bb0e85e0e6543"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := signPayload(tt.secret, tt.timestamp, []byte(tt.payload)); got != tt.want {
+				t.Errorf("signPayload(%q, %q, %q) = %s, want %s", tt.secret, tt.timestamp, tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &webhookDispatcher{baseBackoff: time.Second, maxBackoff: time.Minute}
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},   // 64s is capped
+		{100, time.Minute}, // the shift overflows
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.attempt); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+		}
+	}
+}
+
+func TestLoadLogFailsPendingDeliveries(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []webhookDelivery{
+		{ID: "dlv_done", Status: deliveryPending, CreatedAt: created},
+		{ID: "dlv_cut", Status: deliveryPending, CreatedAt: created.Add(time.Second)},
+		{ID: "dlv_done", Status: deliverySucceeded, Attempts: 1, ResponseCode: 200, CreatedAt: created},
+		{ID: "dlv_gave_up", Status: deliveryFailed, Attempts: 5, LastError: "unexpected status 500", CreatedAt: created.Add(2 * time.Second)},
+	}
+	var lines []string
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	path := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newWebhookDispatcher(nil, path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.logFile.Close()
+
+	want := map[string]struct{ status, lastError string }{
+		"dlv_done":    {deliverySucceeded, ""},
+		"dlv_cut":     {deliveryFailed, "interrupted by server restart"},
+		"dlv_gave_up": {deliveryFailed, "unexpected status 500"},
+	}
+	got := d.Deliveries("")
+	if len(got) != len(want) {
+		t.Fatalf("loaded %d deliveries, want %d: %+v", len(got), len(want), got)
+	}
+	for _, delivery := range got {
+		w := want[delivery.ID]
+		if delivery.Status != w.status || delivery.LastError != w.lastError {
+			t.Errorf("%s is %s (%q), want %s (%q)", delivery.ID, delivery.Status, delivery.LastError, w.status, w.lastError)
+		}
+	}
+	if failed := d.Deliveries(deliveryFailed); len(failed) != 2 {
+		t.Errorf("%d failed deliveries, want 2", len(failed))
+	}
+}
+
+func TestLoadLogKeepsLatestFinishedDeliveries(t *testing.T) {
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var lines []string
+	for i, status := range []string{deliverySucceeded, deliveryFailed, deliverySucceeded, deliveryPending} {
+		at := created.Add(time.Duration(i) * time.Second)
+		line, err := json.Marshal(webhookDelivery{ID: fmt.Sprintf("dlv_%d", i), Status: status, CreatedAt: at, UpdatedAt: at})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, string(line))
+	}
+	path := filepath.Join(t.TempDir(), "deliveries.jsonl")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := newWebhookDispatcher(nil, path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.logFile.Close()
+
+	var ids []string
+	for _, delivery := range d.Deliveries("") {
+		ids = append(ids, delivery.ID)
+	}
+	if got := strings.Join(ids, " "); got != "dlv_2 dlv_3" {
+		t.Errorf("kept %q, want the two latest", got)
+	}
+
+	// The log is rewritten with what was kept, so the next start reads less
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 2 {
+		t.Errorf("log holds %d records after loading, want 2", n)
+	}
+}